@@ -0,0 +1,74 @@
+package checker
+
+import (
+	"fmt"
+
+	"github.com/opsee/basic/schema"
+)
+
+// Aggregation modes, see schema.AggregationPolicy.
+const (
+	AggregateAll     = "all"
+	AggregateAny     = "any"
+	AggregateAtLeast = "at_least"
+	AggregatePercent = "percent"
+)
+
+// validateAggregationPolicy returns an error if a check's aggregation policy
+// can't be applied. A nil policy requires every target to pass.
+func validateAggregationPolicy(policy *schema.AggregationPolicy) error {
+	if policy == nil {
+		return nil
+	}
+
+	switch policy.Mode {
+	case "", AggregateAll, AggregateAny:
+	case AggregateAtLeast:
+		if policy.MinPassing < 1 {
+			return fmt.Errorf("Aggregation policy requires at least 1 passing target: %d", policy.MinPassing)
+		}
+	case AggregatePercent:
+		if policy.MinPercent <= 0 || policy.MinPercent > 100 {
+			return fmt.Errorf("Aggregation policy percentage must be above 0 and at most 100: %v", policy.MinPercent)
+		}
+	default:
+		return fmt.Errorf("Unknown aggregation policy mode: %s", policy.Mode)
+	}
+
+	return nil
+}
+
+// aggregate returns whether a check passes under policy given its targets'
+// responses, and the fraction of the responses that passed. Checks with no
+// responses only pass if every target is required to.
+func aggregate(policy *schema.AggregationPolicy, responses []*schema.CheckResponse) (bool, float64) {
+	passed := 0
+	for _, response := range responses {
+		if response.Passing {
+			passed++
+		}
+	}
+
+	total := len(responses)
+	ratio := 0.0
+	if total > 0 {
+		ratio = float64(passed) / float64(total)
+	}
+
+	mode := AggregateAll
+	if policy != nil && policy.Mode != "" {
+		mode = policy.Mode
+	}
+
+	switch mode {
+	case AggregateAny:
+		return passed > 0, ratio
+	case AggregateAtLeast:
+		return passed >= int(policy.MinPassing), ratio
+	case AggregatePercent:
+		// Allow for rounding, so that e.g. 1 of 3 meets 33.333...%.
+		return total > 0 && ratio*100+1e-9 >= policy.MinPercent, ratio
+	default:
+		return passed == total, ratio
+	}
+}
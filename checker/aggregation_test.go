@@ -0,0 +1,58 @@
+package checker
+
+import (
+	"testing"
+
+	"github.com/opsee/basic/schema"
+	"github.com/stretchr/testify/assert"
+)
+
+func aggregationTestResponses(passing, failing int) []*schema.CheckResponse {
+	responses := []*schema.CheckResponse{}
+	for i := 0; i < passing; i++ {
+		responses = append(responses, &schema.CheckResponse{Passing: true})
+	}
+	for i := 0; i < failing; i++ {
+		responses = append(responses, &schema.CheckResponse{Passing: false})
+	}
+	return responses
+}
+
+func TestAggregate(t *testing.T) {
+	tests := []struct {
+		policy           *schema.AggregationPolicy
+		passing, failing int
+		expected         bool
+	}{
+		{nil, 3, 0, true},
+		{nil, 2, 1, false},
+		{nil, 0, 0, true},
+		{&schema.AggregationPolicy{Mode: AggregateAll}, 2, 1, false},
+		{&schema.AggregationPolicy{Mode: AggregateAny}, 1, 39, true},
+		{&schema.AggregationPolicy{Mode: AggregateAny}, 0, 3, false},
+		{&schema.AggregationPolicy{Mode: AggregateAny}, 0, 0, false},
+		{&schema.AggregationPolicy{Mode: AggregateAtLeast, MinPassing: 2}, 2, 38, true},
+		{&schema.AggregationPolicy{Mode: AggregateAtLeast, MinPassing: 2}, 1, 0, false},
+		{&schema.AggregationPolicy{Mode: AggregatePercent, MinPercent: 90}, 36, 4, true},
+		{&schema.AggregationPolicy{Mode: AggregatePercent, MinPercent: 90}, 35, 5, false},
+		{&schema.AggregationPolicy{Mode: AggregatePercent, MinPercent: 100.0 / 3}, 1, 2, true},
+		{&schema.AggregationPolicy{Mode: AggregatePercent, MinPercent: 50}, 0, 0, false},
+	}
+
+	for _, test := range tests {
+		passing, ratio := aggregate(test.policy, aggregationTestResponses(test.passing, test.failing))
+		assert.Equal(t, test.expected, passing, "%#v with %d passing and %d failing", test.policy, test.passing, test.failing)
+		if total := test.passing + test.failing; total > 0 {
+			assert.InDelta(t, float64(test.passing)/float64(total), ratio, 1e-9)
+		}
+	}
+}
+
+func TestValidateAggregationPolicy(t *testing.T) {
+	assert.NoError(t, validateAggregationPolicy(nil))
+	assert.NoError(t, validateAggregationPolicy(&schema.AggregationPolicy{Mode: AggregateAny}))
+	assert.NoError(t, validateAggregationPolicy(&schema.AggregationPolicy{Mode: AggregatePercent, MinPercent: 75}))
+	assert.Error(t, validateAggregationPolicy(&schema.AggregationPolicy{Mode: AggregateAtLeast}))
+	assert.Error(t, validateAggregationPolicy(&schema.AggregationPolicy{Mode: AggregatePercent, MinPercent: 150}))
+	assert.Error(t, validateAggregationPolicy(&schema.AggregationPolicy{Mode: "most"}))
+}
@@ -0,0 +1,181 @@
+package checker
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/client/metadata"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/private/signer/v4"
+	"github.com/opsee/basic/schema"
+	"github.com/opsee/bastion/config"
+)
+
+var (
+	sigV4Mut         sync.Mutex
+	sigV4Credentials *credentials.Credentials
+	sigV4Region      string
+)
+
+// awsCredentials returns the credentials used to sign SigV4 requests, which
+// cache their value until it expires. They're kept once they've been
+// created. It is a variable so that tests may substitute their own.
+var awsCredentials = func() (*credentials.Credentials, error) {
+	sigV4Mut.Lock()
+	defer sigV4Mut.Unlock()
+
+	if sigV4Credentials == nil {
+		sess, err := config.GetConfig().AWS.Session()
+		if err != nil {
+			return nil, err
+		}
+		sigV4Credentials = sess.Config.Credentials
+	}
+	return sigV4Credentials, nil
+}
+
+// awsRegion returns the region used to sign SigV4 requests that do not
+// specify one, which is kept once it's known.
+var awsRegion = func() string {
+	sigV4Mut.Lock()
+	defer sigV4Mut.Unlock()
+
+	if sigV4Region == "" {
+		metaData, err := config.GetConfig().AWS.MetaData()
+		if err != nil {
+			return ""
+		}
+		sigV4Region = metaData.Region
+	}
+	return sigV4Region
+}
+
+// validateAuth checks that auth is of a known type and has what that type
+// needs to authenticate.
+func validateAuth(auth *schema.HttpAuth) error {
+	if auth == nil {
+		return nil
+	}
+
+	switch auth.Type {
+	case "basic":
+		if auth.Username == "" {
+			return fmt.Errorf("Basic auth requires a username")
+		}
+	case "bearer":
+		if auth.Token == "" {
+			return fmt.Errorf("Bearer auth requires a token")
+		}
+	case "sigv4":
+		if auth.Service == "" {
+			return fmt.Errorf("SigV4 auth requires a service")
+		}
+	case "client_cert":
+		if auth.Certificate == "" || auth.Key == "" {
+			return fmt.Errorf("Client certificate auth requires a certificate and key")
+		}
+		if _, err := tls.X509KeyPair([]byte(auth.Certificate), []byte(auth.Key)); err != nil {
+			return fmt.Errorf("Invalid client certificate: %s", err)
+		}
+	default:
+		return fmt.Errorf("Unknown auth type: %s", auth.Type)
+	}
+
+	return nil
+}
+
+// applyTLSAuth adds any client certificate required by auth to tlsConfig.
+func applyTLSAuth(auth *schema.HttpAuth, tlsConfig *tls.Config) error {
+	if auth == nil || auth.Type != "client_cert" {
+		return nil
+	}
+
+	cert, err := tls.X509KeyPair([]byte(auth.Certificate), []byte(auth.Key))
+	if err != nil {
+		return err
+	}
+	tlsConfig.Certificates = []tls.Certificate{cert}
+
+	return nil
+}
+
+// applyHeaderAuth sets the Authorization header for basic and bearer auth.
+func applyHeaderAuth(auth *schema.HttpAuth, header http.Header) {
+	if auth == nil {
+		return
+	}
+
+	switch auth.Type {
+	case "basic":
+		req := &http.Request{Header: header}
+		req.SetBasicAuth(auth.Username, auth.Password)
+	case "bearer":
+		header.Set("Authorization", fmt.Sprintf("Bearer %s", auth.Token))
+	}
+}
+
+// authorize applies auth to an outgoing request. body must be the request
+// body, which SigV4 includes in the signature.
+func authorize(auth *schema.HttpAuth, req *http.Request, body string) error {
+	if auth == nil {
+		return nil
+	}
+
+	switch auth.Type {
+	case "basic", "bearer":
+		applyHeaderAuth(auth, req.Header)
+	case "sigv4":
+		creds, err := awsCredentials()
+		if err != nil {
+			return err
+		}
+		region := auth.Region
+		if region == "" {
+			region = awsRegion()
+		}
+		return signV4(req, body, creds, auth.Service, region, time.Now())
+	case "client_cert":
+		// handled by the TLS configuration
+	default:
+		return fmt.Errorf("Unknown auth type: %s", auth.Type)
+	}
+
+	return nil
+}
+
+// signV4 signs req with AWS Signature Version 4, using the SDK's signer.
+// body must be req's body. The request's Host, if it's set, is what's signed
+// and sent, rather than its URL's.
+func signV4(req *http.Request, body string, creds *credentials.Credentials, service, region string, t time.Time) error {
+	// The client sends req.Host, and ignores a Host header, which the signer
+	// would sign as another header.
+	req.Header.Del("Host")
+	u := *req.URL
+	if req.Host != "" {
+		u.Host = req.Host
+	}
+	signed := *req
+	signed.URL = &u
+
+	r := &request.Request{
+		Config:      aws.Config{Credentials: creds, Region: aws.String(region)},
+		ClientInfo:  metadata.ClientInfo{ServiceName: service},
+		HTTPRequest: &signed,
+		Body:        strings.NewReader(body),
+		Time:        t,
+	}
+	v4.Sign(r)
+	if r.Error != nil {
+		return r.Error
+	}
+
+	// The signer encodes the query canonically.
+	req.URL.RawQuery = u.RawQuery
+	return nil
+}
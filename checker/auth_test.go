@@ -0,0 +1,173 @@
+package checker
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/opsee/basic/schema"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/context"
+)
+
+func authTestServer(t *testing.T, check func(*http.Request) bool) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !check(r) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+}
+
+func authTestCode(t *testing.T, request *HTTPRequest) int32 {
+	resp := <-request.Do(context.Background())
+	assert.NoError(t, resp.Error)
+	return resp.Response.(*schema.CheckResponse_HttpResponse).HttpResponse.Code
+}
+
+func TestAuthBasic(t *testing.T) {
+	ts := authTestServer(t, func(r *http.Request) bool {
+		user, pass, ok := r.BasicAuth()
+		return ok && user == "opsee" && pass == "hunter2"
+	})
+	defer ts.Close()
+
+	request := &HTTPRequest{
+		Method: "GET",
+		URL:    ts.URL,
+		Auth:   &schema.HttpAuth{Type: "basic", Username: "opsee", Password: "hunter2"},
+	}
+	assert.EqualValues(t, 200, authTestCode(t, request))
+}
+
+func TestAuthBearer(t *testing.T) {
+	ts := authTestServer(t, func(r *http.Request) bool {
+		return r.Header.Get("Authorization") == "Bearer secret"
+	})
+	defer ts.Close()
+
+	request := &HTTPRequest{
+		Method: "GET",
+		URL:    ts.URL,
+		Auth:   &schema.HttpAuth{Type: "bearer", Token: "secret"},
+	}
+	assert.EqualValues(t, 200, authTestCode(t, request))
+}
+
+// The get-vanilla case from the AWS SigV4 test suite.
+func TestAuthSigV4(t *testing.T) {
+	req, err := http.NewRequest("GET", "https://example.amazonaws.com/", nil)
+	assert.NoError(t, err)
+
+	creds := credentials.NewStaticCredentials("AKIDEXAMPLE", "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY", "")
+	err = signV4(req, "", creds, "service", "us-east-1", time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC))
+	assert.NoError(t, err)
+
+	assert.Equal(t, "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, SignedHeaders=host;x-amz-date, Signature=5fa00fa31553b73ebf1942676e86291e8372ff2a2260956d9b8aae1d763fbf31", req.Header.Get("Authorization"))
+	assert.Equal(t, "20150830T123600Z", req.Header.Get("X-Amz-Date"))
+}
+
+// Requests to a host other than their URL's are signed for that host, which
+// is what the server sees.
+func TestAuthSigV4Host(t *testing.T) {
+	creds := credentials.NewStaticCredentials("AKIDEXAMPLE", "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY", "")
+	signed := func(url, host string) string {
+		req, err := http.NewRequest("GET", url, nil)
+		assert.NoError(t, err)
+		if host != "" {
+			req.Host = host
+			req.Header.Set("Host", host)
+		}
+		assert.NoError(t, signV4(req, "", creds, "service", "us-east-1", time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC)))
+		assert.Equal(t, url, req.URL.String())
+		return req.Header.Get("Authorization")
+	}
+
+	assert.Equal(t, signed("https://example.amazonaws.com/", ""), signed("https://10.0.0.1/", "example.amazonaws.com"))
+}
+
+func TestAuthClientCertificate(t *testing.T) {
+	certPEM, keyPEM := generateTestCertificate(t)
+
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if len(r.TLS.PeerCertificates) == 0 {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	ts.TLS = &tls.Config{ClientAuth: tls.RequireAnyClientCert}
+	ts.StartTLS()
+	defer ts.Close()
+
+	request := &HTTPRequest{
+		Method:             "GET",
+		URL:                ts.URL,
+		InsecureSkipVerify: true,
+		Auth:               &schema.HttpAuth{Type: "client_cert", Certificate: certPEM, Key: keyPEM},
+	}
+	assert.EqualValues(t, 200, authTestCode(t, request))
+
+	request.Auth = nil
+	resp := <-request.Do(context.Background())
+	assert.Error(t, resp.Error, "handshake should fail without a client certificate")
+}
+
+func TestValidateAuth(t *testing.T) {
+	certPEM, keyPEM := generateTestCertificate(t)
+
+	for _, auth := range []*schema.HttpAuth{
+		nil,
+		{Type: "basic", Username: "user"},
+		{Type: "bearer", Token: "token"},
+		{Type: "sigv4", Service: "es"},
+		{Type: "client_cert", Certificate: certPEM, Key: keyPEM},
+	} {
+		assert.NoError(t, validateAuth(auth), "%v", auth)
+	}
+
+	for _, auth := range []*schema.HttpAuth{
+		{},
+		{Type: "digest"},
+		{Type: "basic", Password: "password"},
+		{Type: "bearer"},
+		{Type: "sigv4", Region: "us-west-2"},
+		{Type: "client_cert", Certificate: certPEM},
+		{Type: "client_cert", Certificate: certPEM, Key: "not a key"},
+	} {
+		assert.Error(t, validateAuth(auth), "%v", auth)
+	}
+}
+
+func generateTestCertificate(t *testing.T) (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "opsee-test"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	assert.NoError(t, err)
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	assert.NoError(t, err)
+
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	return string(certPEM), string(keyPEM)
+}
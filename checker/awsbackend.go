@@ -0,0 +1,313 @@
+package checker
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/opsee/basic/schema"
+	opsee_aws_autoscaling "github.com/opsee/basic/schema/aws/autoscaling"
+	opsee_aws_cloudwatch "github.com/opsee/basic/schema/aws/cloudwatch"
+	opsee_aws_ec2 "github.com/opsee/basic/schema/aws/ec2"
+	opsee_aws_ecs "github.com/opsee/basic/schema/aws/ecs"
+	opsee_aws_elb "github.com/opsee/basic/schema/aws/elb"
+	opsee_aws_rds "github.com/opsee/basic/schema/aws/rds"
+	opsee "github.com/opsee/basic/service"
+	"github.com/opsee/bastion/config"
+	opsee_types "github.com/opsee/protobuf/opseeproto/types"
+	"golang.org/x/net/context"
+)
+
+// AWS backends, as configured by AWS_BACKEND. The configured backend is tried
+// first, and the other is fallen back to if it fails.
+const (
+	AWSBackendBezos  = config.AWSBackendBezos
+	AWSBackendDirect = config.AWSBackendDirect
+)
+
+// An AWSBackend makes the AWS API requests that the resolver and CloudWatch
+// worker need.
+type AWSBackend interface {
+	DescribeInstances(context.Context, *opsee_aws_ec2.DescribeInstancesInput) (*opsee_aws_ec2.DescribeInstancesOutput, error)
+	DescribeAutoScalingGroups(context.Context, *opsee_aws_autoscaling.DescribeAutoScalingGroupsInput) (*opsee_aws_autoscaling.DescribeAutoScalingGroupsOutput, error)
+	DescribeLoadBalancers(context.Context, *opsee_aws_elb.DescribeLoadBalancersInput) (*opsee_aws_elb.DescribeLoadBalancersOutput, error)
+	DescribeDBInstances(context.Context, *opsee_aws_rds.DescribeDBInstancesInput) (*opsee_aws_rds.DescribeDBInstancesOutput, error)
+	ListTasks(context.Context, *opsee_aws_ecs.ListTasksInput) (*opsee_aws_ecs.ListTasksOutput, error)
+	DescribeTasks(context.Context, *opsee_aws_ecs.DescribeTasksInput) (*opsee_aws_ecs.DescribeTasksOutput, error)
+	DescribeContainerInstances(context.Context, *opsee_aws_ecs.DescribeContainerInstancesInput) (*opsee_aws_ecs.DescribeContainerInstancesOutput, error)
+	GetMetricStatistics(context.Context, *opsee_aws_cloudwatch.GetMetricStatisticsInput) (*opsee_aws_cloudwatch.GetMetricStatisticsOutput, error)
+}
+
+// NewAWSBackend returns a backend that tries the one chosen by mode first and
+// falls back to the other. Either may be nil if it isn't available. Modes
+// other than AWSBackendDirect choose bezos, the config having already
+// validated the mode.
+func NewAWSBackend(mode string, bezos *BezosBackend, direct AWSBackend) AWSBackend {
+	var primary, secondary AWSBackend
+	if bezos != nil && bezos.Client != nil {
+		primary = bezos
+	}
+	secondary = direct
+
+	if mode == AWSBackendDirect {
+		primary, secondary = secondary, primary
+	}
+
+	backends := FallbackBackend{}
+	for _, backend := range []AWSBackend{primary, secondary} {
+		if backend != nil {
+			backends = append(backends, backend)
+		}
+	}
+	if len(backends) == 1 {
+		return backends[0]
+	}
+
+	return backends
+}
+
+var (
+	directAWSMut sync.Mutex
+	directAWS    *SDKBackend
+)
+
+// DirectAWS returns the backend that makes requests to AWS itself, using the
+// bastion's own credentials. It's shared once it's been created, and is nil
+// if cfg has no session yet, in which case it's tried again next time.
+func DirectAWS(cfg *config.Config) AWSBackend {
+	directAWSMut.Lock()
+	defer directAWSMut.Unlock()
+
+	if directAWS == nil {
+		sess, err := cfg.AWS.Session()
+		if err != nil {
+			log.WithError(err).Error("Couldn't get AWS session, requests to AWS will only go through bezos.")
+			return nil
+		}
+		directAWS = NewSDKBackend(sess)
+	}
+	return directAWS
+}
+
+// BezosBackend makes AWS requests through bezos on behalf of a customer's
+// VPC, accepting responses cached by bezos up to MaxAge old.
+type BezosBackend struct {
+	Client opsee.BezosClient
+	User   *schema.User
+	Region string
+	VpcId  string
+	MaxAge time.Duration
+}
+
+func (b *BezosBackend) get(ctx context.Context, request *opsee.BezosRequest) (*opsee.BezosResponse, error) {
+	maxAge := &opsee_types.Timestamp{}
+	maxAge.Scan(time.Now().UTC().Add(b.MaxAge * -1))
+
+	request.User = b.User
+	request.Region = b.Region
+	request.VpcId = b.VpcId
+	request.MaxAge = maxAge
+
+	return b.Client.Get(ctx, request)
+}
+
+func (b *BezosBackend) DescribeInstances(ctx context.Context, input *opsee_aws_ec2.DescribeInstancesInput) (*opsee_aws_ec2.DescribeInstancesOutput, error) {
+	resp, err := b.get(ctx, &opsee.BezosRequest{Input: &opsee.BezosRequest_Ec2_DescribeInstancesInput{Ec2_DescribeInstancesInput: input}})
+	if err != nil {
+		return nil, err
+	}
+
+	output := resp.GetEc2_DescribeInstancesOutput()
+	if output == nil {
+		return nil, fmt.Errorf("error decoding aws response")
+	}
+	return output, nil
+}
+
+func (b *BezosBackend) DescribeAutoScalingGroups(ctx context.Context, input *opsee_aws_autoscaling.DescribeAutoScalingGroupsInput) (*opsee_aws_autoscaling.DescribeAutoScalingGroupsOutput, error) {
+	resp, err := b.get(ctx, &opsee.BezosRequest{Input: &opsee.BezosRequest_Autoscaling_DescribeAutoScalingGroupsInput{Autoscaling_DescribeAutoScalingGroupsInput: input}})
+	if err != nil {
+		return nil, err
+	}
+
+	output := resp.GetAutoscaling_DescribeAutoScalingGroupsOutput()
+	if output == nil {
+		return nil, fmt.Errorf("error decoding aws response")
+	}
+	return output, nil
+}
+
+func (b *BezosBackend) DescribeLoadBalancers(ctx context.Context, input *opsee_aws_elb.DescribeLoadBalancersInput) (*opsee_aws_elb.DescribeLoadBalancersOutput, error) {
+	resp, err := b.get(ctx, &opsee.BezosRequest{Input: &opsee.BezosRequest_Elb_DescribeLoadBalancersInput{Elb_DescribeLoadBalancersInput: input}})
+	if err != nil {
+		return nil, err
+	}
+
+	output := resp.GetElb_DescribeLoadBalancersOutput()
+	if output == nil {
+		return nil, fmt.Errorf("error decoding aws response")
+	}
+	return output, nil
+}
+
+func (b *BezosBackend) DescribeDBInstances(ctx context.Context, input *opsee_aws_rds.DescribeDBInstancesInput) (*opsee_aws_rds.DescribeDBInstancesOutput, error) {
+	resp, err := b.get(ctx, &opsee.BezosRequest{Input: &opsee.BezosRequest_Rds_DescribeDBInstancesInput{Rds_DescribeDBInstancesInput: input}})
+	if err != nil {
+		return nil, err
+	}
+
+	output := resp.GetRds_DescribeDBInstancesOutput()
+	if output == nil {
+		return nil, fmt.Errorf("error decoding aws response")
+	}
+	return output, nil
+}
+
+func (b *BezosBackend) ListTasks(ctx context.Context, input *opsee_aws_ecs.ListTasksInput) (*opsee_aws_ecs.ListTasksOutput, error) {
+	resp, err := b.get(ctx, &opsee.BezosRequest{Input: &opsee.BezosRequest_Ecs_ListTasksInput{Ecs_ListTasksInput: input}})
+	if err != nil {
+		return nil, err
+	}
+
+	output := resp.GetEcs_ListTasksOutput()
+	if output == nil {
+		return nil, fmt.Errorf("error decoding aws response")
+	}
+	return output, nil
+}
+
+func (b *BezosBackend) DescribeTasks(ctx context.Context, input *opsee_aws_ecs.DescribeTasksInput) (*opsee_aws_ecs.DescribeTasksOutput, error) {
+	resp, err := b.get(ctx, &opsee.BezosRequest{Input: &opsee.BezosRequest_Ecs_DescribeTasksInput{Ecs_DescribeTasksInput: input}})
+	if err != nil {
+		return nil, err
+	}
+
+	output := resp.GetEcs_DescribeTasksOutput()
+	if output == nil {
+		return nil, fmt.Errorf("error decoding aws response")
+	}
+	return output, nil
+}
+
+func (b *BezosBackend) DescribeContainerInstances(ctx context.Context, input *opsee_aws_ecs.DescribeContainerInstancesInput) (*opsee_aws_ecs.DescribeContainerInstancesOutput, error) {
+	resp, err := b.get(ctx, &opsee.BezosRequest{Input: &opsee.BezosRequest_Ecs_DescribeContainerInstancesInput{Ecs_DescribeContainerInstancesInput: input}})
+	if err != nil {
+		return nil, err
+	}
+
+	output := resp.GetEcs_DescribeContainerInstancesOutput()
+	if output == nil {
+		return nil, fmt.Errorf("error decoding aws response")
+	}
+	return output, nil
+}
+
+func (b *BezosBackend) GetMetricStatistics(ctx context.Context, input *opsee_aws_cloudwatch.GetMetricStatisticsInput) (*opsee_aws_cloudwatch.GetMetricStatisticsOutput, error) {
+	resp, err := b.get(ctx, &opsee.BezosRequest{Input: &opsee.BezosRequest_Cloudwatch_GetMetricStatisticsInput{Cloudwatch_GetMetricStatisticsInput: input}})
+	if err != nil {
+		return nil, err
+	}
+
+	output := resp.GetCloudwatch_GetMetricStatisticsOutput()
+	if output == nil {
+		return nil, fmt.Errorf("error decoding aws response")
+	}
+	return output, nil
+}
+
+// FallbackBackend tries each of its backends in turn until one succeeds. If
+// all of them fail, the first one's error is returned.
+type FallbackBackend []AWSBackend
+
+func (f FallbackBackend) try(ctx context.Context, operation string, call func(AWSBackend) error) error {
+	var first error
+	for i, backend := range f {
+		err := call(backend)
+		if err == nil {
+			return nil
+		}
+		if first == nil {
+			first = err
+		}
+
+		log.WithError(err).WithFields(log.Fields{
+			"operation": operation,
+			"backend":   fmt.Sprintf("%T", backend),
+		}).Warn("AWS request failed")
+
+		// There's no time left to try the others.
+		if ctx.Err() != nil {
+			break
+		}
+		if i+1 < len(f) {
+			log.WithField("operation", operation).Infof("Falling back to %T", f[i+1])
+		}
+	}
+
+	return first
+}
+
+func (f FallbackBackend) DescribeInstances(ctx context.Context, input *opsee_aws_ec2.DescribeInstancesInput) (output *opsee_aws_ec2.DescribeInstancesOutput, err error) {
+	err = f.try(ctx, "DescribeInstances", func(backend AWSBackend) (err error) {
+		output, err = backend.DescribeInstances(ctx, input)
+		return err
+	})
+	return output, err
+}
+
+func (f FallbackBackend) DescribeAutoScalingGroups(ctx context.Context, input *opsee_aws_autoscaling.DescribeAutoScalingGroupsInput) (output *opsee_aws_autoscaling.DescribeAutoScalingGroupsOutput, err error) {
+	err = f.try(ctx, "DescribeAutoScalingGroups", func(backend AWSBackend) (err error) {
+		output, err = backend.DescribeAutoScalingGroups(ctx, input)
+		return err
+	})
+	return output, err
+}
+
+func (f FallbackBackend) DescribeLoadBalancers(ctx context.Context, input *opsee_aws_elb.DescribeLoadBalancersInput) (output *opsee_aws_elb.DescribeLoadBalancersOutput, err error) {
+	err = f.try(ctx, "DescribeLoadBalancers", func(backend AWSBackend) (err error) {
+		output, err = backend.DescribeLoadBalancers(ctx, input)
+		return err
+	})
+	return output, err
+}
+
+func (f FallbackBackend) DescribeDBInstances(ctx context.Context, input *opsee_aws_rds.DescribeDBInstancesInput) (output *opsee_aws_rds.DescribeDBInstancesOutput, err error) {
+	err = f.try(ctx, "DescribeDBInstances", func(backend AWSBackend) (err error) {
+		output, err = backend.DescribeDBInstances(ctx, input)
+		return err
+	})
+	return output, err
+}
+
+func (f FallbackBackend) ListTasks(ctx context.Context, input *opsee_aws_ecs.ListTasksInput) (output *opsee_aws_ecs.ListTasksOutput, err error) {
+	err = f.try(ctx, "ListTasks", func(backend AWSBackend) (err error) {
+		output, err = backend.ListTasks(ctx, input)
+		return err
+	})
+	return output, err
+}
+
+func (f FallbackBackend) DescribeTasks(ctx context.Context, input *opsee_aws_ecs.DescribeTasksInput) (output *opsee_aws_ecs.DescribeTasksOutput, err error) {
+	err = f.try(ctx, "DescribeTasks", func(backend AWSBackend) (err error) {
+		output, err = backend.DescribeTasks(ctx, input)
+		return err
+	})
+	return output, err
+}
+
+func (f FallbackBackend) DescribeContainerInstances(ctx context.Context, input *opsee_aws_ecs.DescribeContainerInstancesInput) (output *opsee_aws_ecs.DescribeContainerInstancesOutput, err error) {
+	err = f.try(ctx, "DescribeContainerInstances", func(backend AWSBackend) (err error) {
+		output, err = backend.DescribeContainerInstances(ctx, input)
+		return err
+	})
+	return output, err
+}
+
+func (f FallbackBackend) GetMetricStatistics(ctx context.Context, input *opsee_aws_cloudwatch.GetMetricStatisticsInput) (output *opsee_aws_cloudwatch.GetMetricStatisticsOutput, err error) {
+	err = f.try(ctx, "GetMetricStatistics", func(backend AWSBackend) (err error) {
+		output, err = backend.GetMetricStatistics(ctx, input)
+		return err
+	})
+	return output, err
+}
@@ -0,0 +1,275 @@
+package checker
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/opsee/basic/schema"
+	opsee_aws_cloudwatch "github.com/opsee/basic/schema/aws/cloudwatch"
+	opsee_aws_ec2 "github.com/opsee/basic/schema/aws/ec2"
+	opsee_aws_ecs "github.com/opsee/basic/schema/aws/ecs"
+	opsee "github.com/opsee/basic/service"
+	"github.com/opsee/bastion/errs"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+)
+
+// fakeAWS answers the AWS API requests made by the SDK backend with canned
+// responses, keyed by action (or X-Amz-Target for JSON APIs).
+type fakeAWS struct {
+	*httptest.Server
+	responses map[string]string
+	requests  []*http.Request
+	bodies    []map[string]interface{}
+}
+
+func newFakeAWS(responses map[string]string) *fakeAWS {
+	f := &fakeAWS{responses: responses}
+	f.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		action := r.Header.Get("X-Amz-Target")
+		if action == "" {
+			action = r.FormValue("Action")
+		} else {
+			body := map[string]interface{}{}
+			json.NewDecoder(r.Body).Decode(&body)
+			f.bodies = append(f.bodies, body)
+		}
+		f.requests = append(f.requests, r)
+
+		response, ok := f.responses[action]
+		if !ok {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprintf(w, `<Response><Errors><Error><Code>InvalidAction</Code><Message>%s</Message></Error></Errors><RequestID>1</RequestID></Response>`, action)
+			return
+		}
+		fmt.Fprint(w, response)
+	}))
+	return f
+}
+
+func (f *fakeAWS) backend() *SDKBackend {
+	return NewSDKBackend(session.New(&aws.Config{
+		Endpoint:    aws.String(f.URL),
+		Region:      aws.String("us-west-2"),
+		Credentials: credentials.NewStaticCredentials("id", "secret", ""),
+		MaxRetries:  aws.Int(0),
+	}))
+}
+
+const describeInstancesResponse = `<DescribeInstancesResponse xmlns="http://ec2.amazonaws.com/doc/2015-10-01/">
+  <requestId>1</requestId>
+  <reservationSet>
+    <item>
+      <reservationId>r-1</reservationId>
+      <instancesSet>
+        <item>
+          <instanceId>i-abc</instanceId>
+          <privateIpAddress>10.0.0.1</privateIpAddress>
+          <launchTime>2016-01-02T03:04:05.000Z</launchTime>
+        </item>
+      </instancesSet>
+    </item>
+  </reservationSet>
+</DescribeInstancesResponse>`
+
+func TestSDKBackendResolvesInstances(t *testing.T) {
+	fake := newFakeAWS(map[string]string{"DescribeInstances": describeInstancesResponse})
+	defer fake.Close()
+
+	resolver := &AWSResolver{VpcId: "vpc-1", Backend: fake.backend()}
+	targets, err := resolver.Resolve(context.Background(), &schema.Target{Type: "instance", Id: "i-abc"})
+	assert.NoError(t, err)
+	assert.Equal(t, []*schema.Target{{Type: "instance", Id: "i-abc", Address: "10.0.0.1"}}, targets)
+
+	if assert.Len(t, fake.requests, 1) {
+		request := fake.requests[0]
+		assert.Equal(t, "i-abc", request.FormValue("InstanceId.1"))
+		assert.Equal(t, "vpc-id", request.FormValue("Filter.1.Name"))
+		assert.Equal(t, "vpc-1", request.FormValue("Filter.1.Value.1"))
+		assert.Contains(t, request.Header.Get("Authorization"), "Credential=id/")
+	}
+
+	output, err := fake.backend().DescribeInstances(context.Background(), &opsee_aws_ec2.DescribeInstancesInput{})
+	assert.NoError(t, err)
+	assert.EqualValues(t, 1451703845000, output.Reservations[0].Instances[0].LaunchTime.Millis())
+}
+
+func TestSDKBackendECS(t *testing.T) {
+	fake := newFakeAWS(map[string]string{
+		"AmazonEC2ContainerServiceV20141113.DescribeTasks": `{"tasks": [{"taskArn": "arn:task", "containerInstanceArn": "arn:ci", "createdAt": 1.451703845E9}], "failures": []}`,
+	})
+	defer fake.Close()
+
+	output, err := fake.backend().DescribeTasks(context.Background(), &opsee_aws_ecs.DescribeTasksInput{
+		Cluster: aws.String("cluster"),
+		Tasks:   []string{"arn:task"},
+	})
+	assert.NoError(t, err)
+	if assert.Len(t, output.Tasks, 1) {
+		assert.Equal(t, "arn:ci", output.Tasks[0].GetContainerInstanceArn())
+		assert.EqualValues(t, 1451703845000, output.Tasks[0].CreatedAt.Millis())
+	}
+	if assert.Len(t, fake.bodies, 1) {
+		assert.Equal(t, map[string]interface{}{"cluster": "cluster", "tasks": []interface{}{"arn:task"}}, fake.bodies[0])
+		assert.Equal(t, "application/x-amz-json-1.1", fake.requests[0].Header.Get("Content-Type"))
+	}
+
+	_, err = fake.backend().ListTasks(context.Background(), &opsee_aws_ecs.ListTasksInput{})
+	assert.Error(t, err)
+}
+
+func TestSDKBackendCloudWatch(t *testing.T) {
+	fake := newFakeAWS(map[string]string{
+		"GetMetricStatistics": `<GetMetricStatisticsResponse xmlns="http://monitoring.amazonaws.com/doc/2010-08-01/">
+  <GetMetricStatisticsResult>
+    <Datapoints>
+      <member>
+        <Timestamp>2016-01-02T03:04:00Z</Timestamp>
+        <Unit>Percent</Unit>
+        <Average>42.5</Average>
+      </member>
+    </Datapoints>
+    <Label>CPUUtilization</Label>
+  </GetMetricStatisticsResult>
+  <ResponseMetadata><RequestId>1</RequestId></ResponseMetadata>
+</GetMetricStatisticsResponse>`,
+	})
+	defer fake.Close()
+
+	request := &CloudWatchRequest{
+		Target:                 &schema.Target{Type: "instance", Id: "i-abc"},
+		Metrics:                []*schema.CloudWatchMetric{{Namespace: "AWS/EC2", Name: "CPUUtilization"}},
+		Namespace:              "AWS/EC2",
+		StatisticsIntervalSecs: 60,
+		StatisticsPeriod:       60,
+		Statistics:             []string{"Average"},
+		Backend:                fake.backend(),
+	}
+
+	response := <-request.Do(context.Background())
+	assert.NoError(t, response.Error)
+	cloudwatchResponse := response.Response.(*schema.CheckResponse_CloudwatchResponse).CloudwatchResponse
+	assert.Empty(t, cloudwatchResponse.Errors)
+	if assert.Len(t, cloudwatchResponse.Metrics, 1) {
+		assert.Equal(t, 42.5, cloudwatchResponse.Metrics[0].Value)
+		assert.Equal(t, "Percent", cloudwatchResponse.Metrics[0].Unit)
+	}
+
+	if assert.Len(t, fake.requests, 1) {
+		assert.Equal(t, "i-abc", fake.requests[0].FormValue("Dimensions.member.1.Value"))
+		assert.NotEmpty(t, fake.requests[0].FormValue("StartTime"))
+	}
+}
+
+// throttledBackend throttles every request for metric statistics.
+type throttledBackend struct {
+	AWSBackend
+}
+
+func (throttledBackend) GetMetricStatistics(ctx context.Context, input *opsee_aws_cloudwatch.GetMetricStatisticsInput) (*opsee_aws_cloudwatch.GetMetricStatisticsOutput, error) {
+	return nil, awserr.New("Throttling", "Rate exceeded", nil)
+}
+
+func TestCloudWatchRequestCategorizesErrors(t *testing.T) {
+	request := &CloudWatchRequest{
+		Target:                 &schema.Target{Type: "instance", Id: "i-abc"},
+		Metrics:                []*schema.CloudWatchMetric{{Namespace: "AWS/EC2", Name: "CPUUtilization"}},
+		Namespace:              "AWS/EC2",
+		StatisticsIntervalSecs: 60,
+		StatisticsPeriod:       60,
+		Statistics:             []string{"Average"},
+		Backend:                throttledBackend{},
+	}
+
+	response := <-request.Do(context.Background())
+	assert.Error(t, response.Error)
+	assert.Equal(t, errs.AWSThrottling, errs.Classify(response.Error))
+	cloudwatchResponse := response.Response.(*schema.CheckResponse_CloudwatchResponse).CloudwatchResponse
+	assert.Len(t, cloudwatchResponse.Errors, 1)
+}
+
+// failingBackend fails every request.
+type failingBackend struct {
+	AWSBackend
+	calls int
+}
+
+func (f *failingBackend) DescribeInstances(ctx context.Context, input *opsee_aws_ec2.DescribeInstancesInput) (*opsee_aws_ec2.DescribeInstancesOutput, error) {
+	f.calls++
+	return nil, errors.New("bezos is unreachable")
+}
+
+func TestFallbackBackend(t *testing.T) {
+	fake := newFakeAWS(map[string]string{"DescribeInstances": describeInstancesResponse})
+	defer fake.Close()
+
+	failing := &failingBackend{}
+	backend := FallbackBackend{failing, fake.backend()}
+	output, err := backend.DescribeInstances(context.Background(), &opsee_aws_ec2.DescribeInstancesInput{})
+	assert.NoError(t, err)
+	assert.Len(t, output.Reservations, 1)
+	assert.Equal(t, 1, failing.calls)
+
+	// The first backend's error is the one returned.
+	backend = FallbackBackend{failing, failing}
+	_, err = backend.DescribeInstances(context.Background(), &opsee_aws_ec2.DescribeInstancesInput{})
+	assert.EqualError(t, err, "bezos is unreachable")
+	assert.Equal(t, 3, failing.calls)
+
+	// Nothing more is tried once the context is done.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = backend.DescribeInstances(ctx, &opsee_aws_ec2.DescribeInstancesInput{})
+	assert.Error(t, err)
+	assert.Equal(t, 4, failing.calls)
+}
+
+// fakeBezosClient answers every request with its response.
+type fakeBezosClient struct {
+	response *opsee.BezosResponse
+	requests []*opsee.BezosRequest
+}
+
+func (f *fakeBezosClient) Get(ctx context.Context, in *opsee.BezosRequest, opts ...grpc.CallOption) (*opsee.BezosResponse, error) {
+	f.requests = append(f.requests, in)
+	return f.response, nil
+}
+
+func TestBezosBackend(t *testing.T) {
+	client := &fakeBezosClient{response: &opsee.BezosResponse{}}
+	backend := &BezosBackend{Client: client, User: &schema.User{Id: 1}, Region: "us-west-2", VpcId: "vpc-1", MaxAge: time.Minute}
+
+	input := &opsee_aws_ec2.DescribeInstancesInput{InstanceIds: []string{"i-abc"}}
+	_, err := backend.DescribeInstances(context.Background(), input)
+	assert.EqualError(t, err, "error decoding aws response")
+
+	if assert.Len(t, client.requests, 1) {
+		request := client.requests[0]
+		assert.Equal(t, input, request.GetEc2_DescribeInstancesInput())
+		assert.Equal(t, "vpc-1", request.VpcId)
+		assert.Equal(t, "us-west-2", request.Region)
+		assert.EqualValues(t, 1, request.User.Id)
+		assert.InDelta(t, time.Now().Add(-time.Minute).Unix(), request.MaxAge.Millis()/1000, 5)
+	}
+}
+
+func TestNewAWSBackend(t *testing.T) {
+	direct := &SDKBackend{}
+	bezos := &BezosBackend{Client: &fakeBezosClient{}}
+
+	assert.Equal(t, FallbackBackend{bezos, direct}, NewAWSBackend("", bezos, direct))
+	assert.Equal(t, FallbackBackend{bezos, direct}, NewAWSBackend(AWSBackendBezos, bezos, direct))
+	assert.Equal(t, FallbackBackend{direct, bezos}, NewAWSBackend(AWSBackendDirect, bezos, direct))
+	assert.Equal(t, direct, NewAWSBackend(AWSBackendBezos, &BezosBackend{}, direct))
+	assert.Equal(t, bezos, NewAWSBackend(AWSBackendDirect, bezos, nil))
+}
@@ -0,0 +1,211 @@
+package checker
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/client"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ecs"
+	"github.com/aws/aws-sdk-go/service/elb"
+	"github.com/aws/aws-sdk-go/service/rds"
+	opsee_aws_autoscaling "github.com/opsee/basic/schema/aws/autoscaling"
+	opsee_aws_cloudwatch "github.com/opsee/basic/schema/aws/cloudwatch"
+	opsee_aws_ec2 "github.com/opsee/basic/schema/aws/ec2"
+	opsee_aws_ecs "github.com/opsee/basic/schema/aws/ecs"
+	opsee_aws_elb "github.com/opsee/basic/schema/aws/elb"
+	opsee_aws_rds "github.com/opsee/basic/schema/aws/rds"
+	opsee_types "github.com/opsee/protobuf/opseeproto/types"
+	"golang.org/x/net/context"
+)
+
+// SDKBackend makes AWS requests itself with aws-sdk-go, using the
+// credentials and region of the session it was made with.
+//
+// The opsee AWS types share their field names with the SDK's, so requests
+// and responses are converted between the two by way of JSON.
+type SDKBackend struct {
+	ec2         *ec2.EC2
+	autoscaling *autoscaling.AutoScaling
+	elb         *elb.ELB
+	rds         *rds.RDS
+	ecs         *ecs.ECS
+	cloudwatch  *cloudwatch.CloudWatch
+}
+
+// NewSDKBackend returns an SDKBackend for clients configured by p, e.g. a
+// session.
+func NewSDKBackend(p client.ConfigProvider, cfgs ...*aws.Config) *SDKBackend {
+	return &SDKBackend{
+		ec2:         ec2.New(p, cfgs...),
+		autoscaling: autoscaling.New(p, cfgs...),
+		elb:         elb.New(p, cfgs...),
+		rds:         rds.New(p, cfgs...),
+		ecs:         ecs.New(p, cfgs...),
+		cloudwatch:  cloudwatch.New(p, cfgs...),
+	}
+}
+
+// send sends req, giving up on it and its retries once ctx is done.
+func send(ctx context.Context, req *request.Request) error {
+	// Each retry starts over with a new http.Request, and is signed before
+	// it's sent.
+	req.Handlers.Sign.PushBack(func(r *request.Request) {
+		r.HTTPRequest = r.HTTPRequest.WithContext(ctx)
+	})
+	req.Handlers.Retry.PushBack(func(r *request.Request) {
+		if ctx.Err() != nil {
+			r.Retryable = aws.Bool(false)
+		}
+	})
+
+	return req.Send()
+}
+
+// convert copies from into to, through their JSON encodings.
+func convert(from, to interface{}) error {
+	b, err := json.Marshal(from)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, to)
+}
+
+func (s *SDKBackend) DescribeInstances(ctx context.Context, input *opsee_aws_ec2.DescribeInstancesInput) (*opsee_aws_ec2.DescribeInstancesOutput, error) {
+	in := &ec2.DescribeInstancesInput{}
+	if err := convert(input, in); err != nil {
+		return nil, err
+	}
+
+	req, out := s.ec2.DescribeInstancesRequest(in)
+	if err := send(ctx, req); err != nil {
+		return nil, err
+	}
+
+	output := &opsee_aws_ec2.DescribeInstancesOutput{}
+	return output, convert(out, output)
+}
+
+func (s *SDKBackend) DescribeAutoScalingGroups(ctx context.Context, input *opsee_aws_autoscaling.DescribeAutoScalingGroupsInput) (*opsee_aws_autoscaling.DescribeAutoScalingGroupsOutput, error) {
+	in := &autoscaling.DescribeAutoScalingGroupsInput{}
+	if err := convert(input, in); err != nil {
+		return nil, err
+	}
+
+	req, out := s.autoscaling.DescribeAutoScalingGroupsRequest(in)
+	if err := send(ctx, req); err != nil {
+		return nil, err
+	}
+
+	output := &opsee_aws_autoscaling.DescribeAutoScalingGroupsOutput{}
+	return output, convert(out, output)
+}
+
+func (s *SDKBackend) DescribeLoadBalancers(ctx context.Context, input *opsee_aws_elb.DescribeLoadBalancersInput) (*opsee_aws_elb.DescribeLoadBalancersOutput, error) {
+	in := &elb.DescribeLoadBalancersInput{}
+	if err := convert(input, in); err != nil {
+		return nil, err
+	}
+
+	req, out := s.elb.DescribeLoadBalancersRequest(in)
+	if err := send(ctx, req); err != nil {
+		return nil, err
+	}
+
+	output := &opsee_aws_elb.DescribeLoadBalancersOutput{}
+	return output, convert(out, output)
+}
+
+func (s *SDKBackend) DescribeDBInstances(ctx context.Context, input *opsee_aws_rds.DescribeDBInstancesInput) (*opsee_aws_rds.DescribeDBInstancesOutput, error) {
+	in := &rds.DescribeDBInstancesInput{}
+	if err := convert(input, in); err != nil {
+		return nil, err
+	}
+
+	req, out := s.rds.DescribeDBInstancesRequest(in)
+	if err := send(ctx, req); err != nil {
+		return nil, err
+	}
+
+	output := &opsee_aws_rds.DescribeDBInstancesOutput{}
+	return output, convert(out, output)
+}
+
+func (s *SDKBackend) ListTasks(ctx context.Context, input *opsee_aws_ecs.ListTasksInput) (*opsee_aws_ecs.ListTasksOutput, error) {
+	in := &ecs.ListTasksInput{}
+	if err := convert(input, in); err != nil {
+		return nil, err
+	}
+
+	req, out := s.ecs.ListTasksRequest(in)
+	if err := send(ctx, req); err != nil {
+		return nil, err
+	}
+
+	output := &opsee_aws_ecs.ListTasksOutput{}
+	return output, convert(out, output)
+}
+
+func (s *SDKBackend) DescribeTasks(ctx context.Context, input *opsee_aws_ecs.DescribeTasksInput) (*opsee_aws_ecs.DescribeTasksOutput, error) {
+	in := &ecs.DescribeTasksInput{}
+	if err := convert(input, in); err != nil {
+		return nil, err
+	}
+
+	req, out := s.ecs.DescribeTasksRequest(in)
+	if err := send(ctx, req); err != nil {
+		return nil, err
+	}
+
+	output := &opsee_aws_ecs.DescribeTasksOutput{}
+	return output, convert(out, output)
+}
+
+func (s *SDKBackend) DescribeContainerInstances(ctx context.Context, input *opsee_aws_ecs.DescribeContainerInstancesInput) (*opsee_aws_ecs.DescribeContainerInstancesOutput, error) {
+	in := &ecs.DescribeContainerInstancesInput{}
+	if err := convert(input, in); err != nil {
+		return nil, err
+	}
+
+	req, out := s.ecs.DescribeContainerInstancesRequest(in)
+	if err := send(ctx, req); err != nil {
+		return nil, err
+	}
+
+	output := &opsee_aws_ecs.DescribeContainerInstancesOutput{}
+	return output, convert(out, output)
+}
+
+func (s *SDKBackend) GetMetricStatistics(ctx context.Context, input *opsee_aws_cloudwatch.GetMetricStatisticsInput) (*opsee_aws_cloudwatch.GetMetricStatisticsOutput, error) {
+	// opsee timestamps are encoded as milliseconds, which the SDK can't read,
+	// so the times are copied over separately.
+	untimed := *input
+	untimed.StartTime = nil
+	untimed.EndTime = nil
+
+	in := &cloudwatch.GetMetricStatisticsInput{}
+	if err := convert(&untimed, in); err != nil {
+		return nil, err
+	}
+	in.StartTime = timestampTime(input.StartTime)
+	in.EndTime = timestampTime(input.EndTime)
+
+	req, out := s.cloudwatch.GetMetricStatisticsRequest(in)
+	if err := send(ctx, req); err != nil {
+		return nil, err
+	}
+
+	output := &opsee_aws_cloudwatch.GetMetricStatisticsOutput{}
+	return output, convert(out, output)
+}
+
+func timestampTime(ts *opsee_types.Timestamp) *time.Time {
+	if ts == nil {
+		return nil
+	}
+	return aws.Time(time.Unix(0, ts.Millis()*int64(time.Millisecond)).UTC())
+}
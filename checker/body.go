@@ -0,0 +1,148 @@
+package checker
+
+import (
+	"bufio"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"strings"
+
+	"golang.org/x/net/context"
+)
+
+const (
+	truncatedMaxContentLength = "max_content_length"
+	truncatedMaxStreamLength  = "max_stream_length"
+	truncatedTimeout          = "timeout"
+	truncatedError            = "error"
+)
+
+// responseBody is the result of streaming a response body.
+type responseBody struct {
+	// body holds at most MaxContentLength bytes of the decoded body.
+	body []byte
+	// length is the number of decoded bytes read.
+	length int64
+	// digest is the hex encoded SHA-256 of the body, if it was read in full.
+	digest string
+	// truncatedReason is empty if body holds the entire response body.
+	truncatedReason string
+}
+
+// headWriter keeps the first max bytes written to it and discards the rest.
+type headWriter struct {
+	buf []byte
+	max int
+}
+
+func (w *headWriter) Write(p []byte) (int, error) {
+	if remaining := w.max - len(w.buf); remaining > 0 {
+		if len(p) < remaining {
+			remaining = len(p)
+		}
+		w.buf = append(w.buf, p[:remaining]...)
+	}
+	return len(p), nil
+}
+
+// decodeBody wraps body in a decompressor if the server compressed it and the
+// transport did not already decode it.
+func decodeBody(resp *http.Response) (io.Reader, error) {
+	if resp.Uncompressed {
+		return resp.Body, nil
+	}
+
+	encoding := strings.ToLower(strings.TrimSpace(resp.Header.Get("Content-Encoding")))
+	if encoding == "" || encoding == "identity" {
+		return resp.Body, nil
+	}
+
+	// Replies to HEAD requests, 204s and 304s carry no body even if they
+	// say how it would have been encoded.
+	rdr := bufio.NewReader(resp.Body)
+	if _, err := rdr.Peek(1); err == io.EOF {
+		return rdr, nil
+	}
+
+	switch encoding {
+	case "gzip", "x-gzip":
+		return gzip.NewReader(rdr)
+	case "deflate":
+		// "deflate" is supposed to mean zlib-wrapped deflate, but plenty of
+		// servers send raw deflate. Peek at the header to tell the two apart.
+		header, err := rdr.Peek(2)
+		if err != nil {
+			return nil, err
+		}
+		if header[0]&0x0f == 8 && (uint16(header[0])<<8|uint16(header[1]))%31 == 0 {
+			return zlib.NewReader(rdr)
+		}
+		return flate.NewReader(rdr), nil
+	}
+
+	return rdr, nil
+}
+
+// readBody streams the entire response body, decoding it if necessary, up to
+// MaxStreamLength bytes or until the context is done. Only the first
+// MaxContentLength bytes are kept. cancel must abort the request, which
+// unblocks a read that has timed out.
+func readBody(ctx context.Context, resp *http.Response, cancel func()) (*responseBody, error) {
+	result := &responseBody{}
+
+	rdr, err := decodeBody(resp)
+	if err != nil {
+		result.truncatedReason = truncatedError
+		return result, err
+	}
+
+	var (
+		hash = sha256.New()
+		head = &headWriter{max: MaxContentLength}
+		done = make(chan struct{})
+	)
+
+	// If the server does not close the connection and there is no Content-Length header,
+	// then the HTTP Client will block indefinitely when trying to read the response body.
+	// So, we have to wrap this in a timeout and cancel the request in order to continue.
+	go func() {
+		defer close(done)
+		result.length, err = io.Copy(io.MultiWriter(hash, head), io.LimitReader(rdr, MaxStreamLength))
+		if err == nil && result.length == MaxStreamLength {
+			// See if there was anything left.
+			var b [1]byte
+			if n, _ := rdr.Read(b[:]); n > 0 {
+				result.truncatedReason = truncatedMaxStreamLength
+			}
+		}
+	}()
+
+	select {
+	case <-ctx.Done():
+		// Calling cancel() here will thread through the http request causing the
+		// response Body ReadCloser to be closed, which unblocks the copy above.
+		cancel()
+		<-done
+		result.truncatedReason = truncatedTimeout
+		err = ctx.Err()
+	case <-done:
+	}
+
+	if err != nil && result.truncatedReason == "" {
+		result.truncatedReason = truncatedError
+	}
+
+	result.body = head.buf
+	if result.truncatedReason == "" {
+		result.digest = hex.EncodeToString(hash.Sum(nil))
+		if result.length > int64(len(result.body)) {
+			result.truncatedReason = truncatedMaxContentLength
+		}
+	}
+
+	return result, err
+}
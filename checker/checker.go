@@ -1,7 +1,6 @@
 package checker
 
 import (
-	"encoding/json"
 	"errors"
 	"fmt"
 	"io/ioutil"
@@ -12,13 +11,13 @@ import (
 	"time"
 
 	log "github.com/Sirupsen/logrus"
-	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/gogo/protobuf/proto"
 	"github.com/nsqio/go-nsq"
 	"github.com/opsee/basic/schema"
 	opsee "github.com/opsee/basic/service"
 	"github.com/opsee/bastion/auth"
 	"github.com/opsee/bastion/config"
+	"github.com/opsee/bastion/errs"
 	"github.com/opsee/bastion/heart"
 	"github.com/satori/go.uuid"
 	"golang.org/x/net/context"
@@ -35,51 +34,30 @@ const (
 	// message types that specify a version number.
 	BastionProtoVersion = 2
 
-	// Time to allow requests to read a response body.
-	BodyReadTimeout = 10 * time.Second
-
 	// Maximum length of response bodies
 	MaxContentLength = 128000
+
+	// Maximum number of response body bytes streamed to compute the body's
+	// digest and length. Only MaxContentLength bytes of these are kept.
+	MaxStreamLength = 10 << 20
+
+	// DefaultDrainTimeout is how long in-flight work is given to finish when
+	// shutting down gracefully.
+	DefaultDrainTimeout = 30 * time.Second
 )
 
+var errShuttingDown = errors.New("Checker is shutting down.")
+
 var (
 	metricsRegistry = heart.MetricsRegistry
 )
 
-// This is admittedly janky, but at the very least it gives us some reasonable
-// and fast insight into what's going on.
-//
-// TODO(greg): This should really be handled in a more elegant manner--the number
-// of possible error scenarios explodes as the number of components increases.
-// So why not strictly define a small set of error types and then categorize all
-// errors accordingly? Probably some simple exception classes for the bastion
-// that those interacting with the bastion can code around. Until then, just
-// fucking slap some shit together.
-
-func handleError(err error) string {
-	errMap := map[string]string{}
-	switch e := err.(type) {
-	default:
-		errMap["type"] = "error"
-		errMap["error"] = err.Error()
-	case awserr.Error:
-		errMap["type"] = "aws"
-		errMap["code"] = e.Code()
-		errMap["error"] = e.Error()
-	case awserr.RequestFailure:
-		errMap["type"] = "aws"
-		errMap["code"] = e.Code()
-		errMap["error"] = e.Message()
-		errMap["requestId"] = e.RequestID()
-	}
-
-	errStr, mErr := json.Marshal(errMap)
-	if mErr != nil {
-		return `{"type": "error", "error": "cannot determine error"}`
-	}
-	log.WithFields(log.Fields{"service": "checker", "event": "handleError() error", "error": err}).Warn(string(errStr))
-
-	return string(errStr)
+// setResponseError records err as the response's error, along with its
+// category so that failures can be grouped by cause.
+func setResponseError(response *schema.CheckResponse, err error) {
+	response.Error = err.Error()
+	response.ErrorCategory = errs.Classify(err)
+	log.WithFields(log.Fields{"service": "checker", "category": response.ErrorCategory, "error": err}).Warn("Check response failed with an error.")
 }
 
 // Interval is the frequency of check execution.
@@ -93,6 +71,7 @@ type RemoteRunner struct {
 	producer   *nsq.Producer
 	config     *NSQRunnerConfig
 	requestMap map[string]chan *schema.CheckResult // TODO(greg): I really want NBHM for Golang. :(
+	onResult   func(*schema.CheckResult)
 	sync.RWMutex
 }
 
@@ -128,15 +107,23 @@ func NewRemoteRunner(cfg *NSQRunnerConfig) (*RemoteRunner, error) {
 
 		log.WithFields(log.Fields{"channel": cfg.ConsumerChannelName, "queue": cfg.ConsumerQueueName}).Debugf("Consumed check id %s", chk.CheckId)
 
-		var respChan chan *schema.CheckResult
+		var (
+			respChan chan *schema.CheckResult
+			onResult func(*schema.CheckResult)
+		)
 
 		r.withLock(func() {
 			respChan = r.requestMap[chk.CheckId]
+			onResult = r.onResult
 			log.Debugf("found response channel for check id %s", chk.CheckId)
 		})
 
+		// Results without a response channel are from scheduled checks.
 		if respChan == nil {
 			log.Debugf("response channel for check id %s is nil", chk.CheckId)
+			if onResult != nil {
+				onResult(chk)
+			}
 			return nil
 		}
 
@@ -169,7 +156,8 @@ func (r *RemoteRunner) withLock(f func()) {
 }
 
 // RunCheck asynchronously executes the check and blocks waiting on the result. It's important to set a
-// context deadline unless you want this to block forever.
+// context deadline unless you want this to block forever. Checks run this way are
+// dispatched in the test lane.
 
 func (r *RemoteRunner) RunCheck(ctx context.Context, checkWithTargets *schema.CheckTargets) (*schema.CheckResult, error) {
 	chk := checkWithTargets.Check
@@ -200,14 +188,22 @@ func (r *RemoteRunner) RunCheck(ctx context.Context, checkWithTargets *schema.Ch
 		})
 	}()
 
+	checkWithTargets.Test = true
 	msg, err := proto.Marshal(checkWithTargets)
 	if err != nil {
 		log.WithError(err).Error("Failed to marshal checkwithtargets")
 		return nil, err
 	}
 
+	// Checks are published to the topic for their type, so that they reach a
+	// runner that handles them.
+	checkType := CheckTypeName(chk)
+	if checkType == "" {
+		return nil, fmt.Errorf("Unrecognized check type.")
+	}
+
 	log.Debug("Publishing request to run check")
-	r.producer.Publish(r.config.ProducerQueueName, msg)
+	r.producer.Publish(CheckTopic(r.config.ProducerQueueName, checkType), msg)
 
 	select {
 	case result := <-respChan:
@@ -219,6 +215,14 @@ func (r *RemoteRunner) RunCheck(ctx context.Context, checkWithTargets *schema.Ch
 	}
 }
 
+// OnResult sets the function called with the results of checks that weren't
+// run with RunCheck.
+func (r *RemoteRunner) OnResult(f func(*schema.CheckResult)) {
+	r.withLock(func() {
+		r.onResult = f
+	})
+}
+
 // Stop blocks until the NSQ consumer and producer are stopped.
 
 func (r *RemoteRunner) Stop() {
@@ -239,6 +243,11 @@ type Checker struct {
 	grpcServer *grpc.Server
 	Runner     *RemoteRunner
 	resolver   Resolver
+
+	sync.Mutex
+	listener net.Listener
+	stopping bool
+	inFlight sync.WaitGroup
 }
 
 // NewChecker sets up the GRPC server for a Checker.
@@ -250,7 +259,26 @@ func NewChecker(r Resolver) *Checker {
 	}
 }
 
+// track records the start of a request, returning the function to call once
+// it's done, or an error if the Checker is shutting down.
+func (c *Checker) track() (func(), error) {
+	c.Lock()
+	defer c.Unlock()
+
+	if c.stopping {
+		return nil, errShuttingDown
+	}
+	c.inFlight.Add(1)
+	return c.inFlight.Done, nil
+}
+
 func (c *Checker) invoke(ctx context.Context, cmd string, req *opsee.CheckResourceRequest) (*opsee.ResourceResponse, error) {
+	done, err := c.track()
+	if err != nil {
+		return nil, err
+	}
+	defer done()
+
 	responses := make([]*opsee.CheckResourceResponse, len(req.Checks))
 	response := &opsee.ResourceResponse{
 		Responses: responses,
@@ -258,21 +286,19 @@ func (c *Checker) invoke(ctx context.Context, cmd string, req *opsee.CheckResour
 	for i, check := range req.Checks {
 		in := []reflect.Value{reflect.ValueOf(check)}
 		out := reflect.ValueOf(c.Scheduler).MethodByName(cmd).Call(in)
-		checkResponse, ok := out[0].Interface().(*schema.Check)
-		if !ok {
-			err, ok := out[1].Interface().(error)
-			if ok {
-				if err != nil {
-					responses[i] = &opsee.CheckResourceResponse{
-						Error: err.Error(),
-					}
-				}
-			}
-		} else {
+		// The scheduler may return a check along with an error, so the error
+		// has to be looked at first.
+		if err, ok := out[1].Interface().(error); ok && err != nil {
 			responses[i] = &opsee.CheckResourceResponse{
 				Id:    check.Id,
-				Check: checkResponse,
+				Error: err.Error(),
 			}
+			continue
+		}
+		checkResponse, _ := out[0].Interface().(*schema.Check)
+		responses[i] = &opsee.CheckResourceResponse{
+			Id:    check.Id,
+			Check: checkResponse,
 		}
 	}
 	return response, nil
@@ -292,12 +318,11 @@ func (c *Checker) RetrieveCheck(ctx context.Context, req *opsee.CheckResourceReq
 	return c.invoke(ctx, "RetrieveCheck", req)
 }
 
-// UpdateCheck deletes and then recreates a check within a request context. It will return an error if there is
-// a problem deleting or creating a check.
+// UpdateCheck replaces a check within a request context, keeping the history of its results. It will return an
+// error if there is a problem replacing the check.
 
 func (c *Checker) UpdateCheck(ctx context.Context, req *opsee.CheckResourceRequest) (*opsee.ResourceResponse, error) {
-	c.invoke(ctx, "DeleteCheck", req)
-	return c.invoke(ctx, "CreateCheck", req)
+	return c.invoke(ctx, "UpdateCheck", req)
 }
 
 // DeleteCheck deletes a check within a request context. It will return an error if there is a problem
@@ -323,10 +348,22 @@ func (c *Checker) DeleteCheck(ctx context.Context, req *opsee.CheckResourceReque
 // ish pattern. to logging, instrumentation, etc.
 
 func (c *Checker) TestCheck(ctx context.Context, req *opsee.TestCheckRequest) (*opsee.TestCheckResponse, error) {
-	log.WithFields(log.Fields{"service": "checker", "event": "TestCheck"}).Info("Handling request: %v", req)
+	done, err := c.track()
+	if err != nil {
+		return nil, err
+	}
+	defer done()
+
+	// The request isn't logged as a whole, since the check may carry
+	// credentials for its targets.
+	var checkId string
+	if req.Check != nil {
+		checkId = req.Check.Id
+	}
+	log.WithFields(log.Fields{"service": "checker", "event": "TestCheck", "check_id": checkId, "check_type": CheckTypeName(req.Check)}).Info("Handling request.")
 
 	if req.Deadline == nil {
-		err := fmt.Errorf("Deadline required but missing in request. %v", req)
+		err := fmt.Errorf("Deadline required but missing in request for check %q.", checkId)
 		log.WithFields(log.Fields{"service": "checker", "event": "TestCheck", "error": err.Error()}).Error("Missing deadline in request!")
 		return nil, err
 	}
@@ -345,6 +382,12 @@ func (c *Checker) TestCheck(ctx context.Context, req *opsee.TestCheckRequest) (*
 	if err != nil {
 		return nil, err
 	}
+
+	// Only run the check against the targets we'll respond with, so that the
+	// result doesn't wait on targets whose responses would be discarded.
+	if req.MaxHosts > 0 && int(req.MaxHosts) < len(checkWithTargets.Targets) {
+		checkWithTargets.Targets = checkWithTargets.Targets[:req.MaxHosts]
+	}
 	result, err := c.Runner.RunCheck(ctx, checkWithTargets)
 	// I hate this hot garbage. We have to do this because the Java
 	// GRPC client will throw exceptions if we return errors via GRPC.
@@ -490,6 +533,11 @@ func (c *Checker) Start() error {
 		return err
 	}
 
+	// Results let the scheduler re-check failing checks sooner.
+	if c.Runner != nil {
+		c.Runner.OnResult(c.Scheduler.HandleResult)
+	}
+
 	log.Debug("Getting existing checks")
 	err = c.synchronizeChecks()
 	if err != nil {
@@ -497,6 +545,7 @@ func (c *Checker) Start() error {
 	}
 
 	// Now start and register the GRPC server and allow users to create/edit/etc checks
+	c.listener = listen
 	go c.grpcServer.Serve(listen)
 	opsee.RegisterCheckerServer(c.grpcServer, c)
 
@@ -509,3 +558,36 @@ func (c *Checker) Stop() {
 	c.grpcServer.Stop()
 	c.Scheduler.Stop()
 }
+
+// GracefulStop stops accepting requests, waits up to drainTimeout for the
+// requests being handled to finish, and then stops all of the checker loops,
+// grpc server, etc.
+func (c *Checker) GracefulStop(drainTimeout time.Duration) {
+	c.Lock()
+	c.stopping = true
+	c.Unlock()
+
+	log.WithFields(log.Fields{"drain_timeout": drainTimeout}).Info("Draining checker.")
+
+	// Closing the listener stops the grpc server from accepting connections,
+	// while letting those it has finish their requests.
+	if c.listener != nil {
+		c.listener.Close()
+	}
+
+	drained := make(chan struct{})
+	go func() {
+		c.inFlight.Wait()
+		close(drained)
+	}()
+
+	timer := time.NewTimer(drainTimeout)
+	select {
+	case <-drained:
+	case <-timer.C:
+		log.Warn("Drain timeout exceeded, stopping with requests in flight.")
+	}
+	timer.Stop()
+
+	c.Stop()
+}
@@ -5,6 +5,7 @@ package checker
 // worth testing.
 
 import (
+	"net"
 	"strings"
 	"testing"
 	"time"
@@ -16,6 +17,7 @@ import (
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/suite"
 	"golang.org/x/net/context"
+	"google.golang.org/grpc"
 )
 
 type testPublisher struct {
@@ -63,11 +65,11 @@ func (s *CheckerTestSuite) SetupSuite() {
 	s.ResetNsqConfig = resetNsqConfig{
 		Topics: []NsqTopic{
 			NsqTopic{s.RunnerConfig.ProducerQueueName},
-			NsqTopic{s.RunnerConfig.ConsumerQueueName},
+			NsqTopic{CheckTopic(s.RunnerConfig.ConsumerQueueName, HTTPCheckType)},
 		},
 		Channels: []NsqChannel{
 			NsqChannel{s.RunnerConfig.ProducerQueueName, "test-check-results"},
-			NsqChannel{s.RunnerConfig.ConsumerQueueName, s.RunnerConfig.ConsumerChannelName},
+			NsqChannel{CheckTopic(s.RunnerConfig.ConsumerQueueName, HTTPCheckType), s.RunnerConfig.ConsumerChannelName},
 		},
 	}
 }
@@ -287,6 +289,58 @@ func (s *CheckerTestSuite) TestUpdateCheck() {
 	assert.Equal(s.T(), newInterval, resp.Responses[0].Check.Interval)
 }
 
+// TestCheckerRejectsDependencyCycle serves the checker without a runner, since
+// creating and updating checks only involves the scheduler.
+func TestCheckerRejectsDependencyCycle(t *testing.T) {
+	checker := NewChecker(newTestResolver())
+	checker.Scheduler = NewScheduler(checker.resolver)
+	opsee.RegisterCheckerServer(checker.grpcServer, checker)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go checker.grpcServer.Serve(listener)
+	defer checker.grpcServer.Stop()
+
+	port := listener.Addr().(*net.TCPAddr).Port
+	client, err := NewRpcClient("127.0.0.1", port)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	common := TestCommonStubs{}
+	parent := common.PassingCheck()
+	parent.Id = "parent"
+	child := common.PassingCheck()
+	child.Id = "child"
+	child.DependsOn = []string{"parent"}
+	resp, err := client.Client.CreateCheck(context.Background(), &opsee.CheckResourceRequest{
+		Checks: []*schema.Check{parent, child},
+	})
+	assert.NoError(t, err)
+	for _, r := range resp.Responses {
+		assert.Empty(t, r.Error)
+	}
+
+	cyclic := common.PassingCheck()
+	cyclic.Id = "parent"
+	cyclic.DependsOn = []string{"child"}
+	for _, call := range []func(context.Context, *opsee.CheckResourceRequest, ...grpc.CallOption) (*opsee.ResourceResponse, error){
+		client.Client.CreateCheck,
+		client.Client.UpdateCheck,
+	} {
+		resp, err = call(context.Background(), &opsee.CheckResourceRequest{Checks: []*schema.Check{cyclic}})
+		assert.NoError(t, err)
+		if assert.Len(t, resp.Responses, 1) {
+			assert.Equal(t, "parent", resp.Responses[0].Id)
+			assert.Nil(t, resp.Responses[0].Check)
+			assert.Contains(t, resp.Responses[0].Error, "cycle")
+		}
+	}
+}
+
 func TestCheckerTestSuite(t *testing.T) {
 	setupTestEnv()
 	suite.Run(t, new(CheckerTestSuite))
@@ -0,0 +1,38 @@
+package checker
+
+import (
+	"fmt"
+
+	"github.com/opsee/basic/schema"
+)
+
+const (
+	HTTPCheckType       = "http"
+	CloudWatchCheckType = "cloudwatch"
+
+	// RunnerTopic is the base name of the topics the scheduler publishes
+	// checks to. See CheckTopic.
+	RunnerTopic = "runner"
+)
+
+// CheckTypes are the names of every check type a runner can handle.
+var CheckTypes = []string{HTTPCheckType, CloudWatchCheckType}
+
+// CheckTypeName returns the name of the type of check, or "" if the check's
+// type isn't known. HTTP transaction checks are run by HTTP runners.
+func CheckTypeName(check *schema.Check) string {
+	switch check.GetSpec().(type) {
+	case *schema.Check_HttpCheck, *schema.Check_HttpTransactionCheck:
+		return HTTPCheckType
+	case *schema.Check_CloudwatchCheck:
+		return CloudWatchCheckType
+	}
+	return ""
+}
+
+// CheckTopic returns the NSQ topic that checks of the named type are published
+// to, given the base topic name. Each check type has its own topic so that
+// runners only receive the checks they handle.
+func CheckTopic(topic, checkType string) string {
+	return fmt.Sprintf("%s_%s", topic, checkType)
+}
@@ -6,6 +6,7 @@ import (
 	"golang.org/x/net/context"
 
 	"github.com/opsee/basic/schema"
+	"github.com/opsee/bastion/errs"
 )
 
 func NewCheckTargets(resolver Resolver, check *schema.Check) (*schema.CheckTargets, error) {
@@ -13,13 +14,14 @@ func NewCheckTargets(resolver Resolver, check *schema.Check) (*schema.CheckTarge
 		return nil, fmt.Errorf("resolveRequestTargets: Check requires target. CHECK=%#v", check)
 	}
 
+	// Errors that aren't more specific, e.g. throttling, are resolution errors.
 	targets, err := resolver.Resolve(context.Background(), check.Target)
 	if err != nil {
-		return nil, err
+		return nil, errs.Wrap(err, errs.Resolution)
 	}
 
 	if len(targets) == 0 {
-		return nil, fmt.Errorf("No valid targets resolved from %s", check.Target)
+		return nil, errs.Errorf(errs.Resolution, "No valid targets resolved from %s", check.Target)
 	}
 
 	return &schema.CheckTargets{
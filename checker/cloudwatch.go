@@ -15,6 +15,7 @@ import (
 	opsee_aws_cloudwatch "github.com/opsee/basic/schema/aws/cloudwatch"
 	opsee "github.com/opsee/basic/service"
 	"github.com/opsee/bastion/config"
+	"github.com/opsee/bastion/errs"
 	opsee_types "github.com/opsee/protobuf/opseeproto/types"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials"
@@ -70,6 +71,10 @@ type CloudWatchRequest struct {
 	StatisticsIntervalSecs int
 	StatisticsPeriod       int
 	Statistics             []string
+	RetryPolicy            *schema.RetryPolicy
+	// Backend makes the requests for metric statistics. If it's nil, they're
+	// made through BezosClient.
+	Backend AWSBackend
 }
 
 type MetricStatisticsResponse struct {
@@ -127,8 +132,28 @@ func (this *CloudWatchRequest) Do(ctx context.Context) <-chan *Response {
 	respChan := make(chan *Response, 1)
 	responseMetrics := []*schema.Metric{}
 	responseErrors := []*opsee_types.Error{}
+	// The first metric that couldn't be fetched fails the response, so that
+	// the failure is categorized.
+	var responseError error
+
+	backend := this.Backend
+	if backend == nil {
+		backend = &BezosBackend{
+			Client: BezosClient,
+			User:   this.User,
+			Region: this.Region,
+			VpcId:  this.VpcId,
+			MaxAge: this.MaxAge * 2,
+		}
+	}
 
 	for _, metric := range this.Metrics {
+		// Don't start on another metric once the check has timed out.
+		if err := ctx.Err(); err != nil {
+			respChan <- &Response{Error: err}
+			return respChan
+		}
+
 		// 1 minute lag.  otherwise we won't get stats
 		endTs := &opsee_types.Timestamp{}
 		startTs := &opsee_types.Timestamp{}
@@ -142,8 +167,9 @@ func (this *CloudWatchRequest) Do(ctx context.Context) <-chan *Response {
 		if err != nil {
 			log.WithError(err).Error("Couldn't get dimensions")
 			responseErrors = append(responseErrors, opsee_types.NewError(metric.Name, err.Error()))
-
-			// TODO(dan) add error to CloudWatchResponse
+			if responseError == nil {
+				responseError = errs.Wrapf(err, "Couldn't get dimensions for %s", metric.Name)
+			}
 			continue
 		}
 
@@ -162,26 +188,13 @@ func (this *CloudWatchRequest) Do(ctx context.Context) <-chan *Response {
 			log.Debugf("aws cloudwatch get-metric-statistics --metric-name %s --start-time %s --end-time %s --period %d --namespace %s --statistics Average --dimensions Name=%s,Value=%s", metric.Name, startTime.Format("2006-01-02T15:04:05"), endTime.Format("2006-01-02T15:04:05"), this.StatisticsPeriod, metric.Namespace, *dimensions[0].Name, *dimensions[0].Value)
 		}
 
-		maxAge := &opsee_types.Timestamp{}
-		maxAge.Scan(time.Now().UTC().Add(this.MaxAge * -2))
-
-		resp, err := BezosClient.Get(
-			ctx,
-			&opsee.BezosRequest{
-				User:   this.User,
-				Region: this.Region,
-				VpcId:  this.VpcId,
-				MaxAge: maxAge,
-				Input:  &opsee.BezosRequest_Cloudwatch_GetMetricStatisticsInput{params},
-			})
+		output, err := backend.GetMetricStatistics(ctx, params)
 		if err != nil {
-			// TODO(dan) add error to CloudWatchResponse
 			log.WithError(err).Errorf("Couldn't get metric statistics for %s", metric.Name)
-			continue
-		}
-		output := resp.GetCloudwatch_GetMetricStatisticsOutput()
-		if output == nil {
-			log.WithError(err).Errorf("error decoding aws response")
+			responseErrors = append(responseErrors, opsee_types.NewError(metric.Name, err.Error()))
+			if responseError == nil {
+				responseError = errs.Wrapf(err, "Couldn't get metric statistics for %s", metric.Name)
+			}
 			continue
 		}
 
@@ -243,6 +256,7 @@ func (this *CloudWatchRequest) Do(ctx context.Context) <-chan *Response {
 
 	respChan <- &Response{
 		Response: &schema.CheckResponse_CloudwatchResponse{cloudwatchResponse},
+		Error:    responseError,
 	}
 
 	return respChan
@@ -273,17 +287,20 @@ func (this *CloudWatchWorker) Work(ctx context.Context, task *Task) *Task {
 	request, ok := task.Request.(*CloudWatchRequest)
 	if ok {
 		log.Debugf("Cloudwatch request: %v", request)
-		select {
-		case response := <-request.Do(ctx):
-			if response.Error != nil {
-				log.WithError(response.Error).Errorf("error processing request: %s", *task)
-			}
-			task.Response = response
-		case <-ctx.Done():
-			task.Response = &Response{
-				Error: ctx.Err(),
+		response := withRetries(ctx, request.RetryPolicy, func(ctx context.Context) *Response {
+			select {
+			case response := <-request.Do(ctx):
+				return response
+			case <-ctx.Done():
+				return &Response{
+					Error: ctx.Err(),
+				}
 			}
+		}, request.failure)
+		if response.Error != nil {
+			log.WithError(response.Error).Errorf("error processing request: %s", *task)
 		}
+		task.Response = response
 	} else {
 		task.Response = &Response{
 			Error: fmt.Errorf("Unable to process request: %s", task.Request),
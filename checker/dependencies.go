@@ -0,0 +1,94 @@
+package checker
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/opsee/basic/schema"
+)
+
+// Dependency modes, see schema.Check's dependency_mode.
+const (
+	DependencySuppress = "suppress"
+	DependencySkip     = "skip"
+)
+
+func validateDependencies(check *schema.Check) error {
+	switch check.DependencyMode {
+	case "", DependencySuppress, DependencySkip:
+	default:
+		return fmt.Errorf("Unknown dependency mode: %s", check.DependencyMode)
+	}
+
+	for _, id := range check.DependsOn {
+		if id == check.Id {
+			return fmt.Errorf("Check depends on itself: %s", check.Id)
+		}
+	}
+
+	return nil
+}
+
+// failing returns true if the check has failed at least its minimum failing
+// count in a row.
+func (c *CheckTimer) failing() bool {
+	c.Lock()
+	defer c.Unlock()
+	return c.failures >= c.threshold()
+}
+
+// dependencyCycle returns the IDs of a cycle of dependencies that check would
+// complete if it were added to the schedule map, or nil if there's none.
+// Checks that aren't scheduled have no dependencies. The caller must hold the
+// lock.
+func (m *scheduleMap) dependencyCycle(check *schema.Check) []string {
+	dependsOn := func(id string) []string {
+		if id == check.Id {
+			return check.DependsOn
+		}
+		if ct, ok := m.checks[id]; ok {
+			return ct.Check.DependsOn
+		}
+		return nil
+	}
+
+	// Depth first, tracking the path from check so that it can be reported.
+	visited := make(map[string]bool)
+	var visit func(path []string) []string
+	visit = func(path []string) []string {
+		for _, parent := range dependsOn(path[len(path)-1]) {
+			if parent == check.Id {
+				return append(path, parent)
+			}
+			if visited[parent] {
+				continue
+			}
+			visited[parent] = true
+			if cycle := visit(append(path, parent)); cycle != nil {
+				return cycle
+			}
+		}
+		return nil
+	}
+
+	return visit([]string{check.Id})
+}
+
+// FailingParents returns the IDs of the checks that check depends on whose
+// latest results failed.
+func (m *scheduleMap) FailingParents(check *schema.Check) []string {
+	m.RLock()
+	defer m.RUnlock()
+
+	failing := []string{}
+	for _, id := range check.DependsOn {
+		if ct, ok := m.checks[id]; ok && ct.failing() {
+			failing = append(failing, id)
+		}
+	}
+	return failing
+}
+
+func dependencyCycleError(cycle []string) error {
+	return fmt.Errorf("Check dependencies form a cycle: %s", strings.Join(cycle, " -> "))
+}
@@ -0,0 +1,215 @@
+package checker
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"time"
+	"unicode/utf8"
+
+	"github.com/opsee/basic/schema"
+	"golang.org/x/net/context"
+)
+
+const (
+	// DiagnosticsTimeout bounds the time spent gathering diagnostics for a
+	// failed request, within what's left of the check's timeout.
+	DiagnosticsTimeout = 5 * time.Second
+	// DiagnosticsFirstBytes is the most of a response that diagnostics keep.
+	DiagnosticsFirstBytes = 512
+)
+
+// diagnose gathers evidence of why the request might have failed by going
+// through the motions of it step by step: looking up the host, connecting to
+// the first of its addresses, shaking hands if it speaks TLS, and reading
+// the start of the response to a bare GET. It stops at the first step that
+// fails.
+func (r *HTTPRequest) diagnose(ctx context.Context) *schema.HttpDiagnostics {
+	diagnostics := &schema.HttpDiagnostics{}
+
+	u, err := url.Parse(r.URL)
+	if err != nil {
+		diagnostics.ConnectError = err.Error()
+		return diagnostics
+	}
+
+	secure := u.Scheme == "https" || u.Scheme == "wss"
+	port := u.Port()
+	if port == "" {
+		port = "80"
+		if secure {
+			port = "443"
+		}
+	}
+
+	host := u.Hostname()
+	diagnostics.Host = host
+
+	ip := host
+	if net.ParseIP(host) == nil {
+		addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+		if err != nil {
+			diagnostics.DnsError = err.Error()
+			return diagnostics
+		}
+		for _, addr := range addrs {
+			diagnostics.DnsAnswers = append(diagnostics.DnsAnswers, addr.IP.String())
+		}
+		ip = addrs[0].IP.String()
+	} else {
+		diagnostics.DnsAnswers = []string{host}
+	}
+
+	diagnostics.ConnectAddress = net.JoinHostPort(ip, port)
+	t0 := time.Now()
+	conn, err := (&net.Dialer{}).DialContext(ctx, "tcp", diagnostics.ConnectAddress)
+	diagnostics.ConnectLatency = time.Since(t0).Seconds() * 1000
+	if err != nil {
+		diagnostics.ConnectError = err.Error()
+		return diagnostics
+	}
+	defer conn.Close()
+
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	if secure {
+		serverName := r.Host
+		if serverName == "" {
+			serverName = host
+		}
+		// Verify the peer's certificates after the handshake, rather than
+		// during it, so that they are known even if they can't be verified.
+		tlsConfig := &tls.Config{
+			ServerName:         serverName,
+			InsecureSkipVerify: true,
+		}
+		if err := applyTLSAuth(r.Auth, tlsConfig); err != nil {
+			diagnostics.TlsError = err.Error()
+			return diagnostics
+		}
+		tlsConn := tls.Client(conn, tlsConfig)
+		err := tlsConn.Handshake()
+
+		state := tlsConn.ConnectionState()
+		for _, cert := range state.PeerCertificates {
+			diagnostics.TlsPeerCertificates = append(diagnostics.TlsPeerCertificates, cert.Subject.String())
+		}
+		if err == nil && !r.InsecureSkipVerify {
+			err = verifyPeerCertificates(state.PeerCertificates, serverName)
+		}
+		if err != nil {
+			diagnostics.TlsError = err.Error()
+			return diagnostics
+		}
+		diagnostics.TlsVersion = tlsVersionName(state.Version)
+		diagnostics.TlsCipherSuite = tlsCipherSuiteName(state.CipherSuite)
+		conn = tlsConn
+	}
+
+	requestHost := r.Host
+	if requestHost == "" {
+		requestHost = u.Host
+	}
+	if _, err := fmt.Fprintf(conn, "GET %s HTTP/1.1\r\nHost: %s\r\nConnection: close\r\n\r\n", u.RequestURI(), requestHost); err != nil {
+		diagnostics.ReadError = err.Error()
+		return diagnostics
+	}
+
+	// A single read is enough to see what the server speaks.
+	buf := make([]byte, DiagnosticsFirstBytes)
+	n, err := conn.Read(buf)
+	if err != nil {
+		diagnostics.ReadError = err.Error()
+	}
+	diagnostics.FirstBytes = printable(buf[:n])
+
+	return diagnostics
+}
+
+// verifyPeerCertificates verifies the certificates a server presented, the
+// way the TLS client would have, against the system's roots.
+func verifyPeerCertificates(certs []*x509.Certificate, serverName string) error {
+	if len(certs) == 0 {
+		return errors.New("tls: server presented no certificates")
+	}
+
+	opts := x509.VerifyOptions{
+		DNSName:       serverName,
+		Intermediates: x509.NewCertPool(),
+	}
+	for _, cert := range certs[1:] {
+		opts.Intermediates.AddCert(cert)
+	}
+	_, err := certs[0].Verify(opts)
+	return err
+}
+
+var tlsVersionNames = map[uint16]string{
+	tls.VersionSSL30: "SSLv3",
+	tls.VersionTLS10: "TLS 1.0",
+	tls.VersionTLS11: "TLS 1.1",
+	tls.VersionTLS12: "TLS 1.2",
+	0x0304:           "TLS 1.3",
+}
+
+// tlsVersionName returns the name of a TLS version, or its hex value if it
+// isn't known.
+func tlsVersionName(version uint16) string {
+	if name, ok := tlsVersionNames[version]; ok {
+		return name
+	}
+	return fmt.Sprintf("0x%04X", version)
+}
+
+var tlsCipherSuiteNames = map[uint16]string{
+	tls.TLS_RSA_WITH_RC4_128_SHA:                "TLS_RSA_WITH_RC4_128_SHA",
+	tls.TLS_RSA_WITH_3DES_EDE_CBC_SHA:           "TLS_RSA_WITH_3DES_EDE_CBC_SHA",
+	tls.TLS_RSA_WITH_AES_128_CBC_SHA:            "TLS_RSA_WITH_AES_128_CBC_SHA",
+	tls.TLS_RSA_WITH_AES_256_CBC_SHA:            "TLS_RSA_WITH_AES_256_CBC_SHA",
+	tls.TLS_RSA_WITH_AES_128_CBC_SHA256:         "TLS_RSA_WITH_AES_128_CBC_SHA256",
+	tls.TLS_RSA_WITH_AES_128_GCM_SHA256:         "TLS_RSA_WITH_AES_128_GCM_SHA256",
+	tls.TLS_RSA_WITH_AES_256_GCM_SHA384:         "TLS_RSA_WITH_AES_256_GCM_SHA384",
+	tls.TLS_ECDHE_ECDSA_WITH_RC4_128_SHA:        "TLS_ECDHE_ECDSA_WITH_RC4_128_SHA",
+	tls.TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA:    "TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA",
+	tls.TLS_ECDHE_ECDSA_WITH_AES_256_CBC_SHA:    "TLS_ECDHE_ECDSA_WITH_AES_256_CBC_SHA",
+	tls.TLS_ECDHE_RSA_WITH_RC4_128_SHA:          "TLS_ECDHE_RSA_WITH_RC4_128_SHA",
+	tls.TLS_ECDHE_RSA_WITH_3DES_EDE_CBC_SHA:     "TLS_ECDHE_RSA_WITH_3DES_EDE_CBC_SHA",
+	tls.TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA:      "TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA",
+	tls.TLS_ECDHE_RSA_WITH_AES_256_CBC_SHA:      "TLS_ECDHE_RSA_WITH_AES_256_CBC_SHA",
+	tls.TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA256: "TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA256",
+	tls.TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA256:   "TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA256",
+	tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256:   "TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256",
+	tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256: "TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256",
+	tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384:   "TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384",
+	tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384: "TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384",
+	tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305:    "TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256",
+	tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305:  "TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256",
+	// TLS 1.3 suites.
+	0x1301: "TLS_AES_128_GCM_SHA256",
+	0x1302: "TLS_AES_256_GCM_SHA384",
+	0x1303: "TLS_CHACHA20_POLY1305_SHA256",
+}
+
+// tlsCipherSuiteName returns the name of a cipher suite, or its hex value if
+// it isn't known.
+func tlsCipherSuiteName(id uint16) string {
+	if name, ok := tlsCipherSuiteNames[id]; ok {
+		return name
+	}
+	return fmt.Sprintf("0x%04X", id)
+}
+
+// printable returns b as a string, escaped if it isn't valid UTF-8.
+func printable(b []byte) string {
+	if utf8.Valid(b) {
+		return string(b)
+	}
+	s := strconv.Quote(string(b))
+	return s[1 : len(s)-1]
+}
@@ -0,0 +1,137 @@
+package checker
+
+import (
+	"crypto/tls"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/opsee/basic/schema"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/context"
+)
+
+func TestDiagnoseHTTP(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	}))
+	defer ts.Close()
+
+	request := &HTTPRequest{Method: "GET", URL: ts.URL + "/status"}
+	diagnostics := request.diagnose(context.Background())
+
+	assert.Equal(t, "127.0.0.1", diagnostics.Host)
+	assert.Equal(t, []string{"127.0.0.1"}, diagnostics.DnsAnswers)
+	assert.Equal(t, strings.TrimPrefix(ts.URL, "http://"), diagnostics.ConnectAddress)
+	assert.Empty(t, diagnostics.ConnectError)
+	assert.Empty(t, diagnostics.TlsVersion)
+	assert.True(t, strings.HasPrefix(diagnostics.FirstBytes, "HTTP/1.1 418"), diagnostics.FirstBytes)
+}
+
+func TestDiagnoseConnectRefused(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	addr := l.Addr().String()
+	l.Close()
+
+	request := &HTTPRequest{Method: "GET", URL: "http://" + addr + "/"}
+	diagnostics := request.diagnose(context.Background())
+
+	assert.Equal(t, addr, diagnostics.ConnectAddress)
+	assert.NotEmpty(t, diagnostics.ConnectError)
+	assert.Empty(t, diagnostics.FirstBytes)
+}
+
+func TestDiagnoseTLS(t *testing.T) {
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer ts.Close()
+
+	// The test server's certificate isn't trusted.
+	request := &HTTPRequest{Method: "GET", URL: ts.URL}
+	diagnostics := request.diagnose(context.Background())
+	assert.NotEmpty(t, diagnostics.TlsError)
+	assert.NotEmpty(t, diagnostics.TlsPeerCertificates)
+	assert.Empty(t, diagnostics.FirstBytes)
+
+	request.InsecureSkipVerify = true
+	diagnostics = request.diagnose(context.Background())
+	assert.Empty(t, diagnostics.TlsError)
+	assert.NotEmpty(t, diagnostics.TlsVersion)
+	assert.NotEmpty(t, diagnostics.TlsCipherSuite)
+	assert.True(t, strings.HasPrefix(diagnostics.FirstBytes, "HTTP/1.1 200"), diagnostics.FirstBytes)
+}
+
+func TestDiagnoseClientCertificate(t *testing.T) {
+	certPEM, keyPEM := generateTestCertificate(t)
+
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	ts.TLS = &tls.Config{ClientAuth: tls.RequireAnyClientCert}
+	ts.StartTLS()
+	defer ts.Close()
+
+	request := &HTTPRequest{
+		Method:             "GET",
+		URL:                ts.URL,
+		InsecureSkipVerify: true,
+		Auth:               &schema.HttpAuth{Type: "client_cert", Certificate: certPEM, Key: keyPEM},
+	}
+	diagnostics := request.diagnose(context.Background())
+	assert.Empty(t, diagnostics.TlsError)
+	assert.Empty(t, diagnostics.ReadError)
+	assert.True(t, strings.HasPrefix(diagnostics.FirstBytes, "HTTP/1.1 200"), diagnostics.FirstBytes)
+}
+
+func TestHTTPWorkerDiagnosesWithinDeadline(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(200 * time.Millisecond)
+	}))
+	defer ts.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	worker := NewHTTPWorker(make(chan Worker, 1))
+	t0 := time.Now()
+	task := worker.Work(ctx, &Task{
+		Request: &HTTPRequest{Method: "GET", URL: ts.URL, Diagnostics: true},
+	})
+	assert.Error(t, task.Response.Error)
+	assert.Nil(t, task.Response.Diagnostics)
+	assert.True(t, time.Since(t0) < 150*time.Millisecond, "the worker must not be held past the deadline")
+}
+
+func TestHTTPWorkerDiagnosesFailures(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	addr := l.Addr().String()
+	l.Close()
+
+	worker := NewHTTPWorker(make(chan Worker, 2))
+	task := worker.Work(context.Background(), &Task{
+		Request: &HTTPRequest{Method: "GET", URL: "http://" + addr + "/", Diagnostics: true},
+	})
+	assert.Error(t, task.Response.Error)
+	if assert.NotNil(t, task.Response.Diagnostics) {
+		assert.NotEmpty(t, task.Response.Diagnostics.ConnectError)
+	}
+
+	task = worker.Work(context.Background(), &Task{
+		Request: &HTTPRequest{Method: "GET", URL: "http://" + addr + "/"},
+	})
+	assert.Nil(t, task.Response.Diagnostics)
+}
+
+func TestPrintable(t *testing.T) {
+	assert.Equal(t, "HTTP/1.1 200 OK\r\n", printable([]byte("HTTP/1.1 200 OK\r\n")))
+	assert.Equal(t, `ok\xff`, printable([]byte{'o', 'k', 0xff}))
+}
+
+func TestTLSNames(t *testing.T) {
+	assert.Equal(t, "TLS 1.2", tlsVersionName(tls.VersionTLS12))
+	assert.Equal(t, "0x0999", tlsVersionName(0x0999))
+	assert.Equal(t, "TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256", tlsCipherSuiteName(tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256))
+	assert.Equal(t, "0xFFFF", tlsCipherSuiteName(0xffff))
+}
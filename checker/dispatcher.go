@@ -12,30 +12,57 @@ import (
 
 const (
 	MaxRoutinesPerWorkerType = 10
-	MaxQueueDepth            = 10
+
+	// Dispatch lanes. Tasks are dispatched in the lane set on their context
+	// with WithLane, or LaneScheduled if none is.
+	LaneTest      = "test"
+	LaneRetry     = "retry"
+	LaneScheduled = "scheduled"
 )
 
-type workerGroup struct {
-	WorkerQueue chan Worker
-	workerFunc  NewWorkerFunc
+// A LaneReservation is the number of a worker group's workers reserved for
+// tasks in a lane.
+type LaneReservation struct {
+	Lane    string
+	Workers int
 }
 
-// A TaskGroup is the unit of work for a Dispatcher.
-type TaskGroup []*Task
+// LaneReservations are the workers of each worker group reserved for tasks in
+// particular lanes, so that a backlog of scheduled checks can't hold up a user
+// testing a check. The remaining workers are shared by every lane. Reserved
+// workers are taken out of the pool's size, at most MaxReservedFraction of it,
+// and are given to lanes in this order until that runs out. So a pool of the
+// default size of 10 reserves 2 workers for tests and 1 for retries, leaving 7
+// for scheduled checks.
+var LaneReservations = []LaneReservation{
+	{Lane: LaneTest, Workers: 2},
+	{Lane: LaneRetry, Workers: 2},
+}
 
-func newWorkerGroup(workerFunc NewWorkerFunc, maxQueueDepth int, maxWorkers int) *workerGroup {
-	wg := &workerGroup{
-		workerFunc:  workerFunc,
-		WorkerQueue: make(chan Worker, maxWorkers),
-	}
-	for i := 0; i < maxWorkers; i++ {
-		wg.WorkerQueue <- workerFunc(wg.WorkerQueue)
+// MaxReservedFraction is the most of a worker group's workers that may be
+// reserved for lanes.
+const MaxReservedFraction = 0.3
+
+type laneKey struct{}
+
+// WithLane returns a context whose tasks are dispatched in the named lane.
+func WithLane(ctx context.Context, lane string) context.Context {
+	return context.WithValue(ctx, laneKey{}, lane)
+}
+
+func laneFromContext(ctx context.Context) string {
+	if lane, ok := ctx.Value(laneKey{}).(string); ok {
+		return lane
 	}
-	return wg
+	return LaneScheduled
 }
 
+// A TaskGroup is the unit of work for a Dispatcher.
+type TaskGroup []*Task
+
 type Dispatcher struct {
 	workerGroups map[string]*workerGroup
+	targets      *targetLimiter
 	metrics      metrics.Registry
 }
 
@@ -47,57 +74,125 @@ func NewDispatcher() *Dispatcher {
 	workerGroups := make(map[string]*workerGroup)
 	for _, workerType := range Recruiters.Keys() {
 		if newFunc, ok := Recruiters.Get(workerType); ok {
-			workerGroups[workerType] = newWorkerGroup(newFunc, MaxQueueDepth, MaxRoutinesPerWorkerType)
+			workerGroups[workerType] = newWorkerGroup(workerType, newFunc, workerPoolConfig(workerType), d.metrics)
 		} else {
 			log.Warnf("Couldn't get worker type %s from Recuiters map.", workerType)
 		}
 	}
 	d.workerGroups = workerGroups
+	d.targets = newTargetLimiter(DefaultTargetLimits)
 
 	return d
 }
 
 // Dispatch guarantees that every Task in a TaskGroup has a response. A call to
-// Dispatch will return a channel that is closed when all tasks have finished
-// completion. Cancelling the context will cause Dispatch to insert an error as
-// the response that indicates the context was cancelled.
+// Dispatch returns immediately with a channel on which each task is delivered
+// as soon as it has finished, and which is closed once every task has.
+// Cancelling the context will cause Dispatch to insert an error as the
+// response that indicates the context was cancelled.
 func (d *Dispatcher) Dispatch(ctx context.Context, tg TaskGroup) chan *Task {
+	// Buffered so that workers never wait on a slow reader.
 	finished := make(chan *Task, len(tg))
-	defer close(finished)
+	lane := laneFromContext(ctx)
 
-	wg := &sync.WaitGroup{}
+	go func() {
+		defer close(finished)
 
-	for _, t := range tg {
-		if dl, ok := ctx.Deadline(); ok {
-			log.WithFields(log.Fields{"request": fmt.Sprintf("%#v", t.Request)}).Debugf("deadline is: %s", dl.Sub(time.Now()).String())
+		wg := &sync.WaitGroup{}
+		for _, t := range tg {
+			if dl, ok := ctx.Deadline(); ok {
+				log.WithFields(log.Fields{"request": fmt.Sprintf("%#v", t.Request)}).Debugf("deadline is: %s", dl.Sub(time.Now()).String())
+			}
+			log.WithFields(log.Fields{"request": fmt.Sprintf("%#v", t.Request), "lane": lane}).Debug("Dispatching request.")
+			metrics.GetOrRegisterCounter("task_dispatched", d.metrics).Inc(1)
+
+			wg.Add(1)
+			go func(task *Task) {
+				finished <- d.run(ctx, lane, task)
+				wg.Done()
+			}(t)
 		}
-		log.WithFields(log.Fields{"request": fmt.Sprintf("%#v", t.Request)}).Debug("Dispatching request.")
-		metrics.GetOrRegisterCounter("task_dispatched", d.metrics).Inc(1)
 
-		select {
-		case <-ctx.Done():
-			t.Response = &Response{
-				Error: ctx.Err(),
-			}
+		wg.Wait()
+		log.Debug("Successfully dispatched TaskGroup.")
+	}()
+
+	return finished
+}
+
+// run waits until the task's target may be sent another request and a worker
+// is free, and then has the worker perform the task. If either wait fails, the
+// task's response is the reason why.
+func (d *Dispatcher) run(ctx context.Context, lane string, t *Task) *Task {
+	address := ""
+	if r, ok := t.Request.(targetedRequest); ok {
+		address = r.targetAddress()
+	}
+
+	limited := time.Now()
+	done, err := d.targets.acquire(ctx, address)
+	if err != nil {
+		t.Response = &Response{
+			Error: err,
+		}
 
+		if _, ok := err.(*RateLimitError); ok {
+			log.WithFields(log.Fields{"request": fmt.Sprintf("%#v", t.Request)}).Debug("Request rate limited.")
+			metrics.GetOrRegisterCounter("task_rate_limited", d.metrics).Inc(1)
+		} else {
 			log.WithFields(log.Fields{"request": fmt.Sprintf("%#v", t.Request)}).Debug("Request cancelled.")
 			metrics.GetOrRegisterCounter("task_cancelled", d.metrics).Inc(1)
-			finished <- t
-		case w := <-d.workerGroups[t.Type].WorkerQueue:
-			wg.Add(1)
-			go func(worker Worker, task *Task) {
-				// We rely on the worker to correctly handle context cancellation so that it
-				// immediately returns once the context is cancelled.
-				finished <- worker.Work(ctx, task)
-				log.WithFields(log.Fields{"request": fmt.Sprintf("%#v", task.Request)}).Debug("Request finished.")
-				wg.Done()
-				metrics.GetOrRegisterCounter("task_executed", d.metrics).Inc(1)
-			}(w, t)
 		}
+		return t
 	}
+	defer done()
+	if d.targets.enabled() {
+		metrics.GetOrRegisterTimer("target_limit_wait", d.metrics).UpdateSince(limited)
+	}
+	// Workers retry within the task, so each retry goes through the limits
+	// too.
+	ctx = withTargetLimit(ctx, d.targets, address)
+
+	group := d.workerGroups[t.Type]
+	group.autoResize(time.Now())
+
+	queued := time.Now()
+	w, release, err := group.acquire(ctx, lane)
+	if err != nil {
+		t.Response = &Response{
+			Error: err,
+		}
 
-	wg.Wait()
-	log.Debug("Successfully dispatched TaskGroup. Returning.")
+		log.WithFields(log.Fields{"request": fmt.Sprintf("%#v", t.Request)}).Debug("Request cancelled.")
+		metrics.GetOrRegisterCounter("task_cancelled", d.metrics).Inc(1)
+		return t
+	}
+	metrics.GetOrRegisterTimer(fmt.Sprintf("queue_wait_%s", lane), d.metrics).UpdateSince(queued)
 
-	return finished
+	// We rely on the worker to correctly handle context cancellation so that it
+	// immediately returns once the context is cancelled.
+	t0 := time.Now()
+	t = w.Work(ctx, t)
+	release(time.Since(t0))
+	log.WithFields(log.Fields{"request": fmt.Sprintf("%#v", t.Request)}).Debug("Request finished.")
+	metrics.GetOrRegisterCounter("task_executed", d.metrics).Inc(1)
+
+	return t
+}
+
+// ResizePool sets the size and bounds of the worker pool for a task type.
+// The pool continues to be resized automatically within the new bounds.
+func (d *Dispatcher) ResizePool(taskType string, cfg WorkerPoolConfig) error {
+	group, ok := d.workerGroups[taskType]
+	if !ok {
+		return fmt.Errorf("No workers for task type %s.", taskType)
+	}
+
+	group.Lock()
+	defer group.Unlock()
+
+	group.setBounds(cfg.MinSize, cfg.MaxSize)
+	group.resize(cfg.Size)
+
+	return nil
 }
@@ -149,6 +149,106 @@ func (s *DispatcherTestSuite) TestDispatcherWithCancelledContext() {
 //
 //**********************************************************************************
 
+type dispatcherBlockingRequest struct {
+	block chan struct{}
+}
+
+type dispatcherBlockingWorker struct {
+	WorkerQueue chan Worker
+}
+
+func (r *dispatcherBlockingRequest) Do(ctx context.Context) <-chan *Response {
+	resp := make(chan *Response, 1)
+	if r.block != nil {
+		<-r.block
+	}
+	resp <- &Response{Response: &schema.CheckResponse_HttpResponse{HttpResponse: &schema.HttpResponse{Code: 200}}}
+	return resp
+}
+
+func newDispatcherBlockingWorker(c chan Worker) Worker {
+	return &dispatcherBlockingWorker{WorkerQueue: c}
+}
+
+func (w *dispatcherBlockingWorker) Work(ctx context.Context, t *Task) *Task {
+	defer func() {
+		w.WorkerQueue <- w
+	}()
+	t.Response = <-t.Request.Do(ctx)
+	return t
+}
+
+// A backlog of scheduled tasks must not hold up tasks in the test lane.
+func (s *DispatcherTestSuite) TestDispatcherTestLane() {
+	Recruiters.RegisterWorker("dispatcherBlockingRequest", newDispatcherBlockingWorker)
+	d := NewDispatcher()
+
+	block := make(chan struct{})
+	backlog := TaskGroup{}
+	for i := 0; i < MaxRoutinesPerWorkerType*2; i++ {
+		backlog = append(backlog, &Task{Type: "dispatcherBlockingRequest", Request: &dispatcherBlockingRequest{block}})
+	}
+	scheduled := make(chan chan *Task, 1)
+	go func() {
+		scheduled <- d.Dispatch(s.Context, backlog)
+	}()
+
+	// Give the backlog time to take every shared worker.
+	time.Sleep(50 * time.Millisecond)
+
+	testTasks := TaskGroup{&Task{Type: "dispatcherBlockingRequest", Request: &dispatcherBlockingRequest{}}}
+	tested := make(chan chan *Task, 1)
+	go func() {
+		tested <- d.Dispatch(WithLane(s.Context, LaneTest), testTasks)
+	}()
+
+	timer := time.NewTimer(time.Second)
+	select {
+	case finished := <-tested:
+		t := <-finished
+		assert.NoError(s.T(), t.Response.Error)
+	case <-timer.C:
+		assert.Fail(s.T(), "Test lane task waited behind scheduled tasks.")
+	}
+	timer.Stop()
+
+	close(block)
+	finished := <-scheduled
+	done := 0
+	for range finished {
+		done++
+	}
+	assert.Equal(s.T(), len(backlog), done)
+
+	assert.NotNil(s.T(), d.metrics.Get("dispatcher.queue_wait_test"))
+	assert.NotNil(s.T(), d.metrics.Get("dispatcher.queue_wait_scheduled"))
+}
+
+// Tasks are delivered as they finish, without waiting on slower tasks.
+func (s *DispatcherTestSuite) TestDispatcherStreamsTasks() {
+	Recruiters.RegisterWorker("dispatcherBlockingRequest", newDispatcherBlockingWorker)
+	d := NewDispatcher()
+
+	block := make(chan struct{})
+	slow := &Task{Type: "dispatcherBlockingRequest", Request: &dispatcherBlockingRequest{block}}
+	fast := &Task{Type: "dispatcherBlockingRequest", Request: &dispatcherBlockingRequest{}}
+	finished := d.Dispatch(s.Context, TaskGroup{slow, fast})
+
+	timer := time.NewTimer(time.Second)
+	select {
+	case t := <-finished:
+		assert.Equal(s.T(), fast, t)
+	case <-timer.C:
+		assert.Fail(s.T(), "Finished task waited on a slower task.")
+	}
+	timer.Stop()
+
+	close(block)
+	assert.Equal(s.T(), slow, <-finished)
+	_, ok := <-finished
+	assert.False(s.T(), ok)
+}
+
 func TestDispatcherTestSuite(t *testing.T) {
 	setupTestEnv()
 	suite.Run(t, new(DispatcherTestSuite))
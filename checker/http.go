@@ -1,15 +1,13 @@
 package checker
 
 import (
-	"bufio"
 	"bytes"
 	"crypto/tls"
 	"errors"
 	"fmt"
-	"io"
-	"io/ioutil"
 	"net"
 	"net/http"
+	"net/http/httptrace"
 	"net/url"
 	"strings"
 	"time"
@@ -22,6 +20,10 @@ import (
 
 const (
 	httpWorkerTaskType = "HTTPRequest"
+
+	// DefaultMaxRedirects is the number of redirects followed by checks that
+	// follow redirects but do not specify a limit.
+	DefaultMaxRedirects = 10
 )
 
 // HTTPRequest and HTTPResponse leave their bodies as strings to make life
@@ -34,6 +36,116 @@ type HTTPRequest struct {
 	Headers            []*schema.Header `json:"headers"`
 	Body               string           `json:"body"`
 	InsecureSkipVerify bool             `json:"insecure_skip_verify"`
+	RedirectPolicy     string           `json:"redirect_policy"`
+	MaxRedirects       int              `json:"max_redirects"`
+	// Auth carries credentials, so it is never serialized.
+	Auth *schema.HttpAuth `json:"-"`
+	// KeepAlive requests are sent over connections from the worker's
+	// transport pool, if it has one. Otherwise every request gets a fresh
+	// connection that is closed when the request is done.
+	KeepAlive bool `json:"keep_alive"`
+	// WebSocket scripts the exchange with ws and wss URLs.
+	WebSocket *schema.WebSocketScript `json:"websocket"`
+	// RetryPolicy is applied by the HTTPWorker.
+	RetryPolicy *schema.RetryPolicy `json:"retry_policy"`
+	// Diagnostics are gathered by the HTTPWorker if the request fails.
+	Diagnostics bool `json:"diagnostics"`
+
+	transports *transportPool
+}
+
+// hopRecorder is an http.RoundTripper that records every request made
+// through it, so that we can report on each redirect followed.
+type hopRecorder struct {
+	transport http.RoundTripper
+	hops      []*schema.HttpRedirect
+}
+
+func (h *hopRecorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	t0 := time.Now()
+	resp, err := h.transport.RoundTrip(req)
+	if err != nil {
+		return resp, err
+	}
+
+	h.hops = append(h.hops, &schema.HttpRedirect{
+		Url:     req.URL.String(),
+		Code:    int32(resp.StatusCode),
+		Latency: time.Since(t0).Seconds() * 1000,
+	})
+
+	return resp, nil
+}
+
+// redirects returns the redirect responses received before the final response.
+func (h *hopRecorder) redirects() []*schema.HttpRedirect {
+	if len(h.hops) < 2 {
+		return nil
+	}
+	return h.hops[:len(h.hops)-1]
+}
+
+func validateRedirectPolicy(policy string) error {
+	switch policy {
+	case "", "none", "follow", "same_host":
+		return nil
+	}
+	return fmt.Errorf("Unknown redirect policy: %s", policy)
+}
+
+// checkRedirect implements the request's redirect policy for an http.Client.
+func (r *HTTPRequest) checkRedirect(req *http.Request, via []*http.Request) error {
+	maxRedirects := r.MaxRedirects
+	if maxRedirects <= 0 {
+		maxRedirects = DefaultMaxRedirects
+	}
+
+	switch r.RedirectPolicy {
+	case "follow":
+	case "same_host":
+		if !r.sameHost(req.URL, via[0].URL) {
+			return http.ErrUseLastResponse
+		}
+	default:
+		return http.ErrUseLastResponse
+	}
+
+	if len(via) > maxRedirects {
+		return http.ErrUseLastResponse
+	}
+
+	// keep presenting the explicit host name while we stay on the address we
+	// dialed, along with the port if that has changed
+	if r.Host != "" && req.URL.Hostname() == via[0].URL.Hostname() {
+		req.Host = r.Host
+		if req.URL.Port() != via[0].URL.Port() {
+			req.Host = hostName(r.Host)
+			if port := req.URL.Port(); port != "" {
+				req.Host = net.JoinHostPort(req.Host, port)
+			}
+		}
+	}
+
+	return nil
+}
+
+// sameHost reports whether u is on the host the request started at: either
+// the address it was first sent to or the host name it presented. Ports are
+// ignored, so that e.g. a redirect from http to https stays on the same host.
+func (r *HTTPRequest) sameHost(u, first *url.URL) bool {
+	host := u.Hostname()
+	if strings.EqualFold(host, first.Hostname()) {
+		return true
+	}
+	return r.Host != "" && strings.EqualFold(host, hostName(r.Host))
+}
+
+// hostName returns host without its port, if it has one.
+func hostName(host string) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		return h
+	}
+	return strings.Trim(host, "[]")
 }
 
 func init() {
@@ -62,7 +174,7 @@ func (r *HTTPRequest) isWebSocketRequest() bool {
 	return false
 }
 
-func (r *HTTPRequest) doWebSocket() *Response {
+func (r *HTTPRequest) doWebSocket(ctx context.Context) *Response {
 	response := &Response{}
 
 	tlsConfig := &tls.Config{
@@ -70,9 +182,15 @@ func (r *HTTPRequest) doWebSocket() *Response {
 		InsecureSkipVerify: r.InsecureSkipVerify,
 	}
 
-	dialer := websocket.DefaultDialer
-	dialer.TLSClientConfig = tlsConfig
-	dialer.HandshakeTimeout = 10 * time.Second
+	if r.Auth != nil && r.Auth.Type == "sigv4" {
+		response.Error = errors.New("sigv4 auth is not supported for WebSocket checks")
+		return response
+	}
+
+	if err := applyTLSAuth(r.Auth, tlsConfig); err != nil {
+		response.Error = err
+		return response
+	}
 
 	t0 := time.Now()
 	url, err := url.Parse(r.URL)
@@ -88,6 +206,31 @@ func (r *HTTPRequest) doWebSocket() *Response {
 		url.Scheme = "wss"
 	}
 
+	// We dial (and for wss, handshake TLS) ourselves so that each phase of
+	// the connection can be timed. The dialer then speaks plain ws over the
+	// connection we hand it, so make the port explicit first.
+	originalHost := url.Host
+	if url.Port() == "" {
+		port := "80"
+		if url.Scheme == "wss" {
+			port = "443"
+		}
+		url.Host = net.JoinHostPort(url.Hostname(), port)
+	}
+
+	timer := &requestTimer{}
+	dialer := *websocket.DefaultDialer
+	dialer.HandshakeTimeout = withinDeadline(ctx, DefaultCheckTimeout)
+	if r.WebSocket != nil {
+		dialer.Subprotocols = r.WebSocket.Subprotocols
+	}
+	if url.Scheme == "wss" {
+		dialer.NetDial = timer.netDial(ctx, tlsConfig, dialer.HandshakeTimeout)
+		url.Scheme = "ws"
+	} else {
+		dialer.NetDial = timer.netDial(ctx, nil, dialer.HandshakeTimeout)
+	}
+
 	requestHeader := http.Header{}
 	for _, header := range r.Headers {
 		key := header.Name
@@ -106,11 +249,17 @@ func (r *HTTPRequest) doWebSocket() *Response {
 		}
 	}
 
+	if requestHeader.Get("Host") == "" {
+		requestHeader.Set("Host", originalHost)
+	}
+
 	// if we have set the host explicity, override any user-provided host
 	if r.Host != "" {
 		requestHeader.Set("Host", r.Host)
 	}
 
+	applyHeaderAuth(r.Auth, requestHeader)
+
 	c, resp, err := dialer.Dial(url.String(), requestHeader)
 	if err != nil {
 		log.WithError(err).Error("Failed to dial WebSocket service.")
@@ -119,45 +268,33 @@ func (r *HTTPRequest) doWebSocket() *Response {
 	}
 
 	defer c.Close()
-	err = c.SetReadDeadline(time.Now().Add(BodyReadTimeout))
-	if err != nil {
-		log.WithError(err).Error("Failed to set read deadline on WebSocket connection.")
-		response.Error = err
-		return response
-	}
-
-	requestBody := []byte(r.Body)
-	if len(requestBody) > 0 {
-		if err := c.WriteMessage(websocket.TextMessage, requestBody); err != nil {
-			log.WithError(err).Error("Error writing WebSocket message.")
-			response.Error = err
-		}
-	}
 
-	_, msgBytes, err := c.ReadMessage()
-	if err != nil {
-		log.WithError(err).Error("Error reading WebSocket message.")
+	conversation := newWebSocketConversation(ctx, c, r.webSocketReadTimeout(ctx))
+	defer conversation.close()
 
-		// TODO(greg): We'll be overwriting the response error here if
-		// there was a previous attempt to write to the websocket that
-		// failed. I'm not sure what to do about that right now.
+	if err := conversation.run(r.webSocketSteps()); err != nil {
+		log.WithError(err).Error("WebSocket conversation failed.")
 		response.Error = err
 	}
+	timer.mark(&timer.done)
 
 	httpResponse := &schema.HttpResponse{
 		Code: int32(resp.StatusCode),
-		Metrics: []*schema.Metric{
+		Metrics: append([]*schema.Metric{
 			&schema.Metric{
 				Name:  "request_latency",
 				Value: time.Since(t0).Seconds() * 1000,
 				Unit:  "ms",
 			},
-		},
-		Headers: []*schema.Header{},
+		}, append(timer.metrics(), conversation.metrics...)...),
+		Headers:         []*schema.Header{},
+		RemoteIp:        timer.remoteIP(),
+		WebsocketFrames: conversation.frames,
+		Subprotocol:     c.Subprotocol(),
 	}
 
-	if msgBytes != nil && len(msgBytes) > 0 {
-		httpResponse.Body = string(msgBytes)
+	if len(conversation.lastMessage) > 0 {
+		httpResponse.Body = string(conversation.lastMessage)
 	}
 
 	for k, v := range resp.Header {
@@ -167,9 +304,8 @@ func (r *HTTPRequest) doWebSocket() *Response {
 		httpResponse.Headers = append(httpResponse.Headers, header)
 	}
 
-	return &Response{
-		Response: &schema.CheckResponse_HttpResponse{httpResponse},
-	}
+	response.Response = &schema.CheckResponse_HttpResponse{HttpResponse: httpResponse}
+	return response
 }
 
 func (r *HTTPRequest) Do(ctx context.Context) <-chan *Response {
@@ -178,7 +314,7 @@ func (r *HTTPRequest) Do(ctx context.Context) <-chan *Response {
 	go func() {
 		defer close(respChan)
 		if r.isWebSocketRequest() {
-			respChan <- r.doWebSocket()
+			respChan <- r.doWebSocket(ctx)
 			return
 		}
 
@@ -187,17 +323,9 @@ func (r *HTTPRequest) Do(ctx context.Context) <-chan *Response {
 			InsecureSkipVerify: r.InsecureSkipVerify,
 		}
 
-		client := &http.Client{
-			CheckRedirect: func(_ *http.Request, _ []*http.Request) error {
-				return errors.New("Not following redirect.")
-			},
-			Transport: &http.Transport{
-				TLSClientConfig:       tlsConfig,
-				ResponseHeaderTimeout: 30 * time.Second,
-				Dial: (&net.Dialer{
-					Timeout: 15 * time.Second,
-				}).Dial,
-			},
+		if err := applyTLSAuth(r.Auth, tlsConfig); err != nil {
+			respChan <- &Response{Error: err}
+			return
 		}
 
 		req, err := http.NewRequest(r.Method, r.URL, strings.NewReader(r.Body))
@@ -206,14 +334,39 @@ func (r *HTTPRequest) Do(ctx context.Context) <-chan *Response {
 			return
 		}
 
-		// Close the connection after we're done. It's the polite thing to do.
-		req.Close = true
+		var transport *http.Transport
+		if r.KeepAlive && r.transports != nil {
+			var clientCert string
+			if r.Auth != nil && r.Auth.Type == "client_cert" {
+				clientCert = r.Auth.Certificate + r.Auth.Key
+			}
+			transport = r.transports.get(transportKey(req.URL.Host, tlsConfig, clientCert), tlsConfig)
+		} else {
+			transport = newTransport(tlsConfig)
+			// Close the connection after we're done. It's the polite thing to do.
+			req.Close = true
+		}
+
+		recorder := &hopRecorder{
+			transport: transport,
+		}
+
+		client := &http.Client{
+			CheckRedirect: r.checkRedirect,
+			Transport:     recorder,
+		}
+
 		// Give ourselves an out if we have to cancel the request. Close this
 		// to cancel.
 		cancelChannel := make(chan struct{})
 		cancel := func() { close(cancelChannel) }
 		req.Cancel = cancelChannel
 
+		timer := &requestTimer{}
+		// The request's context bounds the transport's own dial and header
+		// timeouts by the check's.
+		req = req.WithContext(httptrace.WithClientTrace(ctx, timer.clientTrace()))
+
 		for _, header := range r.Headers {
 			key := header.Name
 
@@ -234,22 +387,22 @@ func (r *HTTPRequest) Do(ctx context.Context) <-chan *Response {
 			req.Header.Set("Host", r.Host)
 		}
 
-		t0 := time.Now()
-		// If the http client returns a non-nil response and a non-nil
-		// error, then it may be a redirect. We test.
-		resp, err := client.Do(req)
-		if resp == nil && err != nil {
+		if err := authorize(r.Auth, req, r.Body); err != nil {
 			respChan <- &Response{Error: err}
 			return
 		}
 
-		defer resp.Body.Close()
-
-		if resp.StatusCode < 300 && resp.StatusCode > 399 && err != nil {
+		t0 := time.Now()
+		// Redirects that the policy declines to follow are returned as the
+		// response itself, so any error here is a real one.
+		resp, err := client.Do(req)
+		if err != nil {
 			respChan <- &Response{Error: err}
 			return
 		}
 
+		defer resp.Body.Close()
+
 		log.Debug("Attempting to read body of response...")
 		// WARNING: You cannot do this.
 		//
@@ -262,75 +415,40 @@ func (r *HTTPRequest) Do(ctx context.Context) <-chan *Response {
 		//
 		// For a breakdown of potential messaging costs, see:
 		// https://docs.google.com/a/opsee.co/spreadsheets/d/14Y8DvBkJMhIQoZ11C5_GKeB7NknYyt-fHJaQixkJfKs/edit?usp=sharing
-
-		rdr := bufio.NewReader(resp.Body)
-		var contentLength int64
-
-		if resp.ContentLength >= 0 && resp.ContentLength <= MaxContentLength {
-			contentLength = resp.ContentLength
-		} else {
-			contentLength = MaxContentLength
+		//
+		// So we stream the entire (decoded) body, up to MaxStreamLength, to
+		// compute its digest and length, but only keep MaxContentLength bytes.
+		result, err := readBody(ctx, resp, cancel)
+		if err != nil {
+			log.WithFields(log.Fields{"url": r.URL, "method": r.Method}).WithError(err).Error("Error while reading message body.")
 		}
+		log.WithFields(log.Fields{"Content-Length": resp.ContentLength, "length": result.length, "truncated": result.truncatedReason}).Debug("Read message body.")
 
-		log.WithFields(log.Fields{"Content-Length": resp.ContentLength, "contentLength": contentLength}).Debug("Setting content length.")
-		body := make([]byte, int64(contentLength))
-
-		// ContentLength is unknown.  read what we can
-		if resp.ContentLength == -1 {
-			// If the server does not close the connection and there is no Content-Length header,
-			// then the HTTP Client will block indefinitely when trying to read the response body.
-			// So, we have to wrap this in a timeout and cancel the request in order to continue.
-			var (
-				numBytes int
-				err      error
-			)
-			done := make(chan struct{}, 1)
-
-			go func() {
-				numBytes, err = rdr.Read(body)
-				close(done)
-			}()
-
-			timer := time.NewTimer(BodyReadTimeout)
-			select {
-			case <-timer.C:
-				// Calling cancel() here will thread through the http request causing the
-				// response Body ReadCloser to be closed. The above goroutine will
-				// receive an error in the call to Read(body) and then return, closing
-				// the done channel, BUT IT WILL BE TOO LATE BWAHAHA
-				cancel()
-				err = errors.New("Timed out waiting to read body.")
-			case <-done:
-				// Just continue, really.
-				err = nil
-			}
-			timer.Stop()
-
-			if err != nil {
-				log.WithFields(log.Fields{"url": r.URL, "method": r.Method}).WithError(err).Error("Error while reading message body.")
-			}
-
-			body = bytes.Trim(body, "\x00")
-			log.Debugf("Successfully read %i bytes...", numBytes)
-		} else {
-			body, err = ioutil.ReadAll(io.LimitReader(resp.Body, contentLength)) // read all
-			if err != nil {
-				log.WithFields(log.Fields{"url": r.URL, "method": r.Method}).WithError(err).Error("Error while reading message body.")
-			}
-		}
+		body := result.body
 		body = bytes.TrimSuffix(body, []byte("\n"))
+		timer.mark(&timer.done)
 
 		httpResponse := &schema.HttpResponse{
 			Code: int32(resp.StatusCode),
 			Body: string(body),
-			Metrics: []*schema.Metric{
+			Metrics: append([]*schema.Metric{
 				&schema.Metric{
 					Name:  "request_latency",
 					Value: time.Since(t0).Seconds() * 1000,
 					Unit:  "ms",
 				},
-			},
-			Headers: []*schema.Header{},
+			}, append(timer.metrics(), &schema.Metric{
+				Name:  "body_bytes",
+				Value: float64(result.length),
+				Unit:  "bytes",
+			})...),
+			Headers:          []*schema.Header{},
+			Redirects:        recorder.redirects(),
+			RemoteIp:         timer.remoteIP(),
+			ConnectionReused: timer.connectionReused(),
+			BodyDigest:       result.digest,
+			Truncated:        result.truncatedReason != "",
+			TruncatedReason:  result.truncatedReason,
 		}
 
 		for k, v := range resp.Header {
@@ -340,9 +458,15 @@ func (r *HTTPRequest) Do(ctx context.Context) <-chan *Response {
 			httpResponse.Headers = append(httpResponse.Headers, header)
 		}
 
-		respChan <- &Response{
+		response := &Response{
 			Response: &schema.CheckResponse_HttpResponse{httpResponse},
 		}
+		// A body cut off by the check's timeout fails the request, so that it
+		// is reported as a timeout.
+		if result.truncatedReason == truncatedTimeout {
+			response.Error = err
+		}
+		respChan <- response
 	}()
 
 	return respChan
@@ -350,11 +474,13 @@ func (r *HTTPRequest) Do(ctx context.Context) <-chan *Response {
 
 type HTTPWorker struct {
 	workerQueue chan Worker
+	transports  *transportPool
 }
 
 func NewHTTPWorker(queue chan Worker) Worker {
 	return &HTTPWorker{
 		workerQueue: queue,
+		transports:  newTransportPool(),
 	}
 }
 
@@ -372,19 +498,32 @@ func (w *HTTPWorker) Work(ctx context.Context, task *Task) *Task {
 
 	request, ok := task.Request.(*HTTPRequest)
 	if ok {
+		request.transports = w.transports
 		log.Debug("request: ", request)
-		select {
-		case response := <-request.Do(ctx):
-			if response.Error != nil {
-				log.Error("error processing request: %s", *task)
-				log.Error("error: %s", response.Error.Error())
+		response := withRetries(ctx, request.RetryPolicy, func(ctx context.Context) *Response {
+			select {
+			case response := <-request.Do(ctx):
+				return response
+			case <-ctx.Done():
+				return &Response{
+					Error: ctx.Err(),
+				}
 			}
-			task.Response = response
-		case <-ctx.Done():
-			task.Response = &Response{
-				Error: ctx.Err(),
+		}, request.failure)
+		if response.Error != nil {
+			log.Error("error processing request: %s", *task)
+			log.Error("error: %s", response.Error.Error())
+
+			// Diagnostics only get what's left of the check's time, so
+			// requests abandoned because the check timed out or because
+			// we're shutting down aren't diagnosed.
+			if request.Diagnostics && ctx.Err() == nil {
+				diagCtx, cancel := context.WithTimeout(ctx, DiagnosticsTimeout)
+				response.Diagnostics = request.diagnose(diagCtx)
+				cancel()
 			}
 		}
+		task.Response = response
 
 	} else {
 		task.Response = &Response{
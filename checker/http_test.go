@@ -1,15 +1,22 @@
 package checker
 
 import (
+	"compress/flate"
+	"compress/gzip"
 	"crypto/rand"
+	"crypto/sha256"
 	"encoding/base64"
+	"encoding/hex"
 	"fmt"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"strings"
 	"testing"
 
 	log "github.com/Sirupsen/logrus"
+	"github.com/gorilla/websocket"
 	"github.com/opsee/basic/schema"
 	"github.com/stretchr/testify/assert"
 	"golang.org/x/net/context"
@@ -46,6 +53,175 @@ func TestRedirectResponse(t *testing.T) {
 	assert.Equal(t, "http://redirected/", location, "redirect response should container correct location header")
 }
 
+func redirectTestServer() *httptest.Server {
+	mux := http.NewServeMux()
+	mux.Handle("/one", http.RedirectHandler("/two", 301))
+	mux.Handle("/two", http.RedirectHandler("/three", 302))
+	mux.HandleFunc("/three", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "arrived")
+	})
+	mux.Handle("/away", http.RedirectHandler("http://redirected.invalid/", 301))
+	return httptest.NewServer(mux)
+}
+
+// follow redirects up to the limit and record each hop in the response.
+func TestRedirectFollow(t *testing.T) {
+	ts := redirectTestServer()
+	defer ts.Close()
+
+	requestMaker := &HTTPRequest{Method: "GET", URL: ts.URL + "/one", RedirectPolicy: "follow"}
+	resp := <-requestMaker.Do(context.Background())
+	assert.NoError(t, resp.Error)
+
+	httpResponse := resp.Response.(*schema.CheckResponse_HttpResponse).HttpResponse
+	assert.EqualValues(t, 200, httpResponse.Code)
+	assert.Equal(t, "arrived", httpResponse.Body)
+	assert.Len(t, httpResponse.Redirects, 2)
+	assert.Equal(t, ts.URL+"/one", httpResponse.Redirects[0].Url)
+	assert.EqualValues(t, 301, httpResponse.Redirects[0].Code)
+	assert.Equal(t, ts.URL+"/two", httpResponse.Redirects[1].Url)
+	assert.EqualValues(t, 302, httpResponse.Redirects[1].Code)
+
+	requestMaker = &HTTPRequest{Method: "GET", URL: ts.URL + "/one", RedirectPolicy: "follow", MaxRedirects: 1}
+	resp = <-requestMaker.Do(context.Background())
+	assert.NoError(t, resp.Error)
+
+	httpResponse = resp.Response.(*schema.CheckResponse_HttpResponse).HttpResponse
+	assert.EqualValues(t, 302, httpResponse.Code, "should stop at the redirect past the limit")
+	assert.Len(t, httpResponse.Redirects, 1)
+}
+
+// same_host redirects stop at the first redirect to another host.
+func TestRedirectSameHost(t *testing.T) {
+	ts := redirectTestServer()
+	defer ts.Close()
+
+	requestMaker := &HTTPRequest{Method: "GET", URL: ts.URL + "/away", RedirectPolicy: "same_host"}
+	resp := <-requestMaker.Do(context.Background())
+	assert.NoError(t, resp.Error)
+
+	httpResponse := resp.Response.(*schema.CheckResponse_HttpResponse).HttpResponse
+	assert.EqualValues(t, 301, httpResponse.Code)
+	assert.Empty(t, httpResponse.Redirects)
+
+	requestMaker = &HTTPRequest{Method: "GET", URL: ts.URL + "/one", RedirectPolicy: "same_host"}
+	resp = <-requestMaker.Do(context.Background())
+	assert.NoError(t, resp.Error)
+	assert.EqualValues(t, 200, resp.Response.(*schema.CheckResponse_HttpResponse).HttpResponse.Code)
+}
+
+// same_host follows redirects to the name the request presents, on any port.
+func TestRedirectSameHostName(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/named":
+			_, port, _ := net.SplitHostPort(r.Host)
+			http.Redirect(w, r, "http://localhost:"+port+"/arrived", 301)
+		case "/arrived":
+			fmt.Fprint(w, r.Host)
+		}
+	}))
+	defer ts.Close()
+
+	_, port, _ := net.SplitHostPort(ts.Listener.Addr().String())
+	requestMaker := &HTTPRequest{Method: "GET", URL: ts.URL + "/named", Host: "localhost:" + port, RedirectPolicy: "same_host"}
+	resp := <-requestMaker.Do(context.Background())
+	assert.NoError(t, resp.Error)
+
+	httpResponse := resp.Response.(*schema.CheckResponse_HttpResponse).HttpResponse
+	assert.EqualValues(t, 200, httpResponse.Code)
+	assert.Equal(t, "localhost:"+port, httpResponse.Body)
+	assert.Len(t, httpResponse.Redirects, 1)
+}
+
+func TestSameHost(t *testing.T) {
+	first, _ := url.Parse("http://10.0.0.1:8080/")
+	r := &HTTPRequest{Host: "example.com:8080"}
+	for rawurl, same := range map[string]bool{
+		"http://10.0.0.1:8080/a":   true,
+		"https://10.0.0.1/":        true,
+		"https://example.com/":     true,
+		"http://EXAMPLE.com:8080/": true,
+		"http://other.com:8080/":   false,
+		"http://10.0.0.2:8080/":    false,
+	} {
+		u, _ := url.Parse(rawurl)
+		assert.Equal(t, same, r.sameHost(u, first), rawurl)
+	}
+}
+
+func metricNames(metrics []*schema.Metric) []string {
+	names := []string{}
+	for _, m := range metrics {
+		names = append(names, m.Name)
+	}
+	return names
+}
+
+// responses include a breakdown of request timing and the remote address.
+func TestResponseTiming(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, "ok")
+	}))
+	defer ts.Close()
+
+	requestMaker := &HTTPRequest{Method: "GET", URL: ts.URL}
+	resp := <-requestMaker.Do(context.Background())
+	assert.NoError(t, resp.Error)
+
+	httpResponse := resp.Response.(*schema.CheckResponse_HttpResponse).HttpResponse
+	assert.Equal(t, []string{"request_latency", "connect", "time_to_first_byte", "body_transfer", "body_bytes"}, metricNames(httpResponse.Metrics))
+	assert.Equal(t, "127.0.0.1", httpResponse.RemoteIp)
+}
+
+func TestResponseTimingWebSocket(t *testing.T) {
+	upgrader := websocket.Upgrader{}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		mt, msg, err := c.ReadMessage()
+		if err != nil {
+			return
+		}
+		c.WriteMessage(mt, msg)
+	}))
+	defer ts.Close()
+
+	requestMaker := &HTTPRequest{Method: "GET", URL: strings.Replace(ts.URL, "http", "ws", 1), Body: "hello"}
+	resp := <-requestMaker.Do(context.Background())
+	assert.NoError(t, resp.Error)
+
+	httpResponse := resp.Response.(*schema.CheckResponse_HttpResponse).HttpResponse
+	assert.EqualValues(t, 101, httpResponse.Code)
+	assert.Equal(t, "hello", httpResponse.Body)
+	assert.Equal(t, []string{"request_latency", "connect", "time_to_first_byte", "body_transfer", "round_trip_latency"}, metricNames(httpResponse.Metrics))
+	assert.Equal(t, "127.0.0.1", httpResponse.RemoteIp)
+}
+
+// keep-alive requests share the worker's connections, others never do.
+func TestKeepAlive(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, "ok")
+	}))
+	defer ts.Close()
+
+	pool := newTransportPool()
+	reused := func(keepAlive bool) bool {
+		requestMaker := &HTTPRequest{Method: "GET", URL: ts.URL, KeepAlive: keepAlive, transports: pool}
+		resp := <-requestMaker.Do(context.Background())
+		assert.NoError(t, resp.Error)
+		return resp.Response.(*schema.CheckResponse_HttpResponse).HttpResponse.ConnectionReused
+	}
+
+	assert.False(t, reused(true), "first request opens a connection")
+	assert.True(t, reused(true), "second request reuses it")
+	assert.False(t, reused(false), "fresh requests never reuse connections")
+	assert.Len(t, pool.transports, 1)
+}
+
 // case where http server returns no response body
 func TestResponseEmpty(t *testing.T) {
 	ctx := context.Background()
@@ -130,6 +306,91 @@ func TestResponseTruncate(t *testing.T) {
 	}
 }
 
+func bodyTestResponse(t *testing.T, handler http.HandlerFunc, headers ...*schema.Header) *schema.HttpResponse {
+	ts := httptest.NewServer(handler)
+	defer ts.Close()
+
+	requestMaker := &HTTPRequest{Method: "GET", URL: ts.URL, Headers: headers}
+	resp := <-requestMaker.Do(context.Background())
+	assert.NoError(t, resp.Error)
+	return resp.Response.(*schema.CheckResponse_HttpResponse).HttpResponse
+}
+
+func bodyBytes(metrics []*schema.Metric) float64 {
+	for _, m := range metrics {
+		if m.Name == "body_bytes" {
+			return m.Value
+		}
+	}
+	return -1
+}
+
+func TestResponseDigest(t *testing.T) {
+	body := "opsee"
+	digest := sha256.Sum256([]byte(body))
+
+	resp := bodyTestResponse(t, func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, body)
+	})
+	assert.Equal(t, body, resp.Body)
+	assert.Equal(t, hex.EncodeToString(digest[:]), resp.BodyDigest)
+	assert.EqualValues(t, len(body), bodyBytes(resp.Metrics))
+	assert.False(t, resp.Truncated)
+	assert.Empty(t, resp.TruncatedReason)
+}
+
+func TestResponseDigestTruncated(t *testing.T) {
+	body, err := GenerateRandomString(MaxContentLength + 500)
+	assert.NoError(t, err)
+	digest := sha256.Sum256([]byte(body))
+
+	resp := bodyTestResponse(t, func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, body)
+	})
+	assert.Equal(t, MaxContentLength, len(resp.Body))
+	assert.Equal(t, hex.EncodeToString(digest[:]), resp.BodyDigest, "digest should cover the entire body")
+	assert.EqualValues(t, len(body), bodyBytes(resp.Metrics))
+	assert.True(t, resp.Truncated)
+	assert.Equal(t, "max_content_length", resp.TruncatedReason)
+}
+
+func TestResponseGzip(t *testing.T) {
+	// Setting Accept-Encoding ourselves stops the transport from decoding
+	// the response for us.
+	resp := bodyTestResponse(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Encoding", "gzip")
+		gz := gzip.NewWriter(w)
+		fmt.Fprint(gz, "compressed")
+		gz.Close()
+	}, &schema.Header{Name: "Accept-Encoding", Values: []string{"gzip"}})
+	assert.Equal(t, "compressed", resp.Body)
+	assert.EqualValues(t, len("compressed"), bodyBytes(resp.Metrics))
+}
+
+func TestResponseDeflate(t *testing.T) {
+	resp := bodyTestResponse(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Encoding", "deflate")
+		fl, _ := flate.NewWriter(w, flate.DefaultCompression)
+		fmt.Fprint(fl, "compressed")
+		fl.Close()
+	}, &schema.Header{Name: "Accept-Encoding", Values: []string{"deflate"}})
+	assert.Equal(t, "compressed", resp.Body)
+}
+
+// an empty body isn't decoded, whatever encoding the headers claim.
+func TestResponseEmptyCompressed(t *testing.T) {
+	for _, encoding := range []string{"gzip", "deflate"} {
+		resp := bodyTestResponse(t, func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Encoding", encoding)
+			w.WriteHeader(http.StatusNoContent)
+		}, &schema.Header{Name: "Accept-Encoding", Values: []string{encoding}})
+		assert.EqualValues(t, http.StatusNoContent, resp.Code, encoding)
+		assert.Empty(t, resp.Body, encoding)
+		assert.False(t, resp.Truncated, encoding)
+		assert.Empty(t, resp.TruncatedReason, encoding)
+	}
+}
+
 // https://elithrar.github.io/article/generating-secure-random-numbers-crypto-rand/
 func GenerateRandomBytes(n int) ([]byte, error) {
 	b := make([]byte, n)
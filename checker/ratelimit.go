@@ -0,0 +1,273 @@
+package checker
+
+import (
+	"fmt"
+	"math"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/opsee/bastion/errs"
+	"golang.org/x/net/context"
+)
+
+// TargetLimits caps how hard a runner hits any one target, across every check
+// it runs. Zero values mean no limit.
+type TargetLimits struct {
+	// MaxConcurrent is the most requests that may be made to a target at once.
+	MaxConcurrent int
+	// RequestsPerSecond is the rate at which requests may be made to a target.
+	// Up to one second's worth of requests may be made in a burst.
+	RequestsPerSecond float64
+	// FailFast fails requests that are over the limits instead of queueing
+	// them until they're allowed.
+	FailFast bool
+}
+
+// DefaultTargetLimits are the limits applied by a Dispatcher, and are read
+// when it's created.
+var DefaultTargetLimits = TargetLimits{}
+
+// targetPruneInterval is how often limits for idle targets are forgotten.
+const targetPruneInterval = time.Minute
+
+// A RateLimitError is the response to a request that was over its target's
+// limits.
+type RateLimitError struct {
+	Address string
+}
+
+func (e *RateLimitError) Error() string {
+	return fmt.Sprintf("rate limited: too many requests to %s", e.Address)
+}
+
+// ErrorCategory implements errs.Categorized.
+func (e *RateLimitError) ErrorCategory() string {
+	return errs.RateLimited
+}
+
+// A targetedRequest is a Request made to a single host, and is subject to
+// target limits.
+type targetedRequest interface {
+	targetAddress() string
+}
+
+func (r *HTTPRequest) targetAddress() string {
+	if u, err := url.Parse(r.URL); err == nil {
+		return u.Host
+	}
+	return ""
+}
+
+func (r *HTTPTransactionRequest) targetAddress() string {
+	return r.Address
+}
+
+// targetState is the state of a single target's limits.
+type targetState struct {
+	active int
+	tokens float64
+	last   time.Time
+	// released is closed, and replaced, whenever a request to the target
+	// finishes.
+	released chan struct{}
+}
+
+// A targetLimiter enforces TargetLimits.
+type targetLimiter struct {
+	sync.Mutex
+	limits    TargetLimits
+	targets   map[string]*targetState
+	lastPrune time.Time
+}
+
+func newTargetLimiter(limits TargetLimits) *targetLimiter {
+	return &targetLimiter{
+		limits:    limits,
+		targets:   make(map[string]*targetState),
+		lastPrune: time.Now(),
+	}
+}
+
+func (l *targetLimiter) enabled() bool {
+	return l.limits.MaxConcurrent > 0 || l.limits.RequestsPerSecond > 0
+}
+
+func (l *targetLimiter) burst() float64 {
+	return math.Max(1, math.Ceil(l.limits.RequestsPerSecond))
+}
+
+// state returns the target's state, with its tokens refilled up to now. The
+// caller must hold the lock.
+func (l *targetLimiter) state(address string, now time.Time) *targetState {
+	st, ok := l.targets[address]
+	if !ok {
+		st = &targetState{
+			tokens:   l.burst(),
+			last:     now,
+			released: make(chan struct{}),
+		}
+		l.targets[address] = st
+	}
+
+	if l.limits.RequestsPerSecond > 0 {
+		st.tokens = math.Min(l.burst(), st.tokens+now.Sub(st.last).Seconds()*l.limits.RequestsPerSecond)
+	}
+	st.last = now
+
+	return st
+}
+
+// take claims a request to the target if the limits allow it. Otherwise it
+// returns how long until a token is available, or 0 if the request must wait
+// for another to finish. The caller must hold the lock.
+func (l *targetLimiter) take(st *targetState) (time.Duration, bool) {
+	if l.limits.MaxConcurrent > 0 && st.active >= l.limits.MaxConcurrent {
+		return 0, false
+	}
+
+	if wait, ok := l.takeToken(st); !ok {
+		return wait, false
+	}
+
+	st.active++
+	return 0, true
+}
+
+// takeToken claims a token from the target's bucket if there's one.
+// Otherwise it returns how long until there is. The caller must hold the
+// lock.
+func (l *targetLimiter) takeToken(st *targetState) (time.Duration, bool) {
+	if l.limits.RequestsPerSecond > 0 {
+		if st.tokens < 1 {
+			return time.Duration((1 - st.tokens) / l.limits.RequestsPerSecond * float64(time.Second)), false
+		}
+		st.tokens--
+	}
+	return 0, true
+}
+
+// prune forgets targets without requests in flight whose buckets have
+// refilled, since they're no different from targets never seen. The caller
+// must hold the lock.
+func (l *targetLimiter) prune(now time.Time) {
+	if now.Sub(l.lastPrune) < targetPruneInterval {
+		return
+	}
+	l.lastPrune = now
+
+	for address := range l.targets {
+		if st := l.state(address, now); st.active == 0 && st.tokens >= l.burst() {
+			delete(l.targets, address)
+		}
+	}
+}
+
+// acquire blocks until a request may be made to address, returning the
+// function to call once it's done. If the limiter fails fast, a request over
+// the limits returns a RateLimitError instead.
+func (l *targetLimiter) acquire(ctx context.Context, address string) (func(), error) {
+	if !l.enabled() || address == "" {
+		return func() {}, nil
+	}
+
+	if err := l.wait(ctx, address, l.take); err != nil {
+		return nil, err
+	}
+	return func() { l.release(address) }, nil
+}
+
+// acquireRetry blocks until a request that's already been made to address may
+// be retried. Retries count against the target's rate, but not its
+// concurrency, since the request still holds its place.
+func (l *targetLimiter) acquireRetry(ctx context.Context, address string) error {
+	if l.limits.RequestsPerSecond <= 0 || address == "" {
+		return nil
+	}
+	return l.wait(ctx, address, l.takeToken)
+}
+
+// wait blocks until take succeeds for the target's state, or returns a
+// RateLimitError if the limiter fails fast.
+func (l *targetLimiter) wait(ctx context.Context, address string, take func(*targetState) (time.Duration, bool)) error {
+	for {
+		l.Lock()
+		now := time.Now()
+		l.prune(now)
+		st := l.state(address, now)
+		wait, ok := take(st)
+		if ok {
+			l.Unlock()
+			return nil
+		}
+		released := st.released
+		l.Unlock()
+
+		if l.limits.FailFast {
+			return &RateLimitError{Address: address}
+		}
+
+		// A nil timer channel (when waiting on a request to finish) is never
+		// ready.
+		var (
+			timer   *time.Timer
+			timeout <-chan time.Time
+		)
+		if wait > 0 {
+			timer = time.NewTimer(wait)
+			timeout = timer.C
+		}
+
+		var err error
+		select {
+		case <-released:
+		case <-timeout:
+		case <-ctx.Done():
+			err = ctx.Err()
+		}
+
+		if timer != nil {
+			timer.Stop()
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+type targetLimitKey struct{}
+
+// targetLimit is the limiter a request's retries go through, along with the
+// request's target.
+type targetLimit struct {
+	limiter *targetLimiter
+	address string
+}
+
+// withTargetLimit returns a context whose request retries are subject to the
+// limiter's limits for address.
+func withTargetLimit(ctx context.Context, l *targetLimiter, address string) context.Context {
+	return context.WithValue(ctx, targetLimitKey{}, targetLimit{limiter: l, address: address})
+}
+
+// acquireRetry blocks until the context's request may be retried, if it's
+// subject to target limits.
+func acquireRetry(ctx context.Context) error {
+	if limit, ok := ctx.Value(targetLimitKey{}).(targetLimit); ok {
+		return limit.limiter.acquireRetry(ctx, limit.address)
+	}
+	return nil
+}
+
+func (l *targetLimiter) release(address string) {
+	l.Lock()
+	defer l.Unlock()
+
+	st, ok := l.targets[address]
+	if !ok {
+		return
+	}
+	st.active--
+	close(st.released)
+	st.released = make(chan struct{})
+}
@@ -0,0 +1,96 @@
+package checker
+
+import (
+	"testing"
+	"time"
+
+	"github.com/opsee/basic/schema"
+	"github.com/opsee/bastion/errs"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/context"
+)
+
+func TestTargetLimiterMaxConcurrent(t *testing.T) {
+	l := newTargetLimiter(TargetLimits{MaxConcurrent: 1})
+
+	done, err := l.acquire(context.Background(), "10.0.0.1:80")
+	assert.NoError(t, err)
+
+	// Other targets aren't affected.
+	other, err := l.acquire(context.Background(), "10.0.0.2:80")
+	assert.NoError(t, err)
+	other()
+
+	acquired := make(chan struct{})
+	go func() {
+		done, err := l.acquire(context.Background(), "10.0.0.1:80")
+		assert.NoError(t, err)
+		done()
+		close(acquired)
+	}()
+
+	select {
+	case <-acquired:
+		assert.Fail(t, "Request was not queued behind the one in flight.")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	done()
+	select {
+	case <-acquired:
+	case <-time.After(time.Second):
+		assert.Fail(t, "Queued request was not allowed once the first finished.")
+	}
+}
+
+func TestTargetLimiterFailFast(t *testing.T) {
+	l := newTargetLimiter(TargetLimits{MaxConcurrent: 1, FailFast: true})
+
+	done, err := l.acquire(context.Background(), "10.0.0.1:80")
+	assert.NoError(t, err)
+	defer done()
+
+	_, err = l.acquire(context.Background(), "10.0.0.1:80")
+	assert.IsType(t, &RateLimitError{}, err)
+	assert.Contains(t, err.Error(), "rate limited")
+}
+
+func TestRateLimitErrorCategory(t *testing.T) {
+	l := newTargetLimiter(TargetLimits{MaxConcurrent: 1, FailFast: true})
+	done, err := l.acquire(context.Background(), "10.0.0.1:80")
+	assert.NoError(t, err)
+	defer done()
+
+	_, err = l.acquire(context.Background(), "10.0.0.1:80")
+	assert.Equal(t, errs.RateLimited, errs.Classify(err))
+	assert.Equal(t, errs.RateLimited, errs.Wrap(err, errs.Internal).Category)
+	assert.Equal(t, "rate_limited", errorClass(err))
+	assert.False(t, retryableError(&schema.RetryPolicy{RetryableErrors: []string{"other"}}, err))
+}
+
+func TestTargetLimiterRequestsPerSecond(t *testing.T) {
+	l := newTargetLimiter(TargetLimits{RequestsPerSecond: 20})
+
+	start := time.Now()
+	for i := 0; i < 22; i++ {
+		done, err := l.acquire(context.Background(), "10.0.0.1:80")
+		assert.NoError(t, err)
+		done()
+	}
+
+	// The first 20 are a burst, and the next two wait 50ms each.
+	assert.True(t, time.Since(start) >= 90*time.Millisecond)
+}
+
+func TestTargetLimiterCancelled(t *testing.T) {
+	l := newTargetLimiter(TargetLimits{MaxConcurrent: 1})
+
+	done, err := l.acquire(context.Background(), "10.0.0.1:80")
+	assert.NoError(t, err)
+	defer done()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err = l.acquire(ctx, "10.0.0.1:80")
+	assert.Equal(t, context.DeadlineExceeded, err)
+}
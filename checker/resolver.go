@@ -17,7 +17,6 @@ import (
 	opsee_aws_rds "github.com/opsee/basic/schema/aws/rds"
 	opsee "github.com/opsee/basic/service"
 	"github.com/opsee/bastion/config"
-	opsee_types "github.com/opsee/protobuf/opseeproto/types"
 	"golang.org/x/net/context"
 
 	log "github.com/Sirupsen/logrus"
@@ -44,6 +43,9 @@ type AWSResolver struct {
 	VpcId       string
 	Region      string
 	User        *schema.User
+	// Backend makes the resolver's AWS requests. If it's nil, they're made
+	// through BezosClient.
+	Backend AWSBackend
 }
 
 func NewResolver(bezos opsee.BezosClient, cfg *config.Config) Resolver {
@@ -67,10 +69,28 @@ func NewResolver(bezos opsee.BezosClient, cfg *config.Config) Resolver {
 		Region:      metaData.Region,
 		User:        user,
 	}
+	resolver.Backend = NewAWSBackend(cfg.AWSBackend, resolver.bezosBackend(), DirectAWS(cfg))
 
 	return resolver
 }
 
+func (this *AWSResolver) bezosBackend() *BezosBackend {
+	return &BezosBackend{
+		Client: this.BezosClient,
+		User:   this.User,
+		Region: this.Region,
+		VpcId:  this.VpcId,
+		MaxAge: DefaultResponseCacheTTL,
+	}
+}
+
+func (this *AWSResolver) backend() AWSBackend {
+	if this.Backend != nil {
+		return this.Backend
+	}
+	return this.bezosBackend()
+}
+
 func (this *AWSResolver) resolveSecurityGroup(ctx context.Context, sgId string) ([]*schema.Target, error) {
 	input := &opsee_aws_ec2.DescribeInstancesInput{
 		Filters: []*opsee_aws_ec2.Filter{
@@ -88,6 +108,56 @@ func (this *AWSResolver) resolveSecurityGroup(ctx context.Context, sgId string)
 	return this.resolveEC2InstancesWithInput(ctx, input)
 }
 
+// parseTagFilters parses the ID of a tag target, a comma separated list of
+// key=value pairs, e.g. "service=payments,env=prod", into a filter for each
+// tag. Instances must have all of the tags to match.
+func parseTagFilters(id string) ([]*opsee_aws_ec2.Filter, error) {
+	filters := []*opsee_aws_ec2.Filter{}
+	seen := map[string]bool{}
+
+	for _, pair := range strings.Split(id, ",") {
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" {
+			return nil, fmt.Errorf("Invalid tag target: %q", id)
+		}
+
+		key := strings.TrimSpace(parts[0])
+		if seen[key] {
+			return nil, fmt.Errorf("Invalid tag target, tag %q repeated: %q", key, id)
+		}
+		seen[key] = true
+
+		filters = append(filters, &opsee_aws_ec2.Filter{
+			Name:   aws.String("tag:" + key),
+			Values: []string{strings.TrimSpace(parts[1])},
+		})
+	}
+
+	return filters, nil
+}
+
+func (this *AWSResolver) resolveTags(ctx context.Context, id string) ([]*schema.Target, error) {
+	tagFilters, err := parseTagFilters(id)
+	if err != nil {
+		return nil, err
+	}
+
+	input := &opsee_aws_ec2.DescribeInstancesInput{
+		Filters: append([]*opsee_aws_ec2.Filter{
+			{
+				Name:   aws.String("vpc-id"),
+				Values: []string{this.VpcId},
+			},
+			{
+				Name:   aws.String("instance-state-name"),
+				Values: []string{"running"},
+			},
+		}, tagFilters...),
+	}
+
+	return this.resolveEC2InstancesWithInput(ctx, input)
+}
+
 func (this *AWSResolver) resolveEC2Instances(ctx context.Context, instanceIds ...string) ([]*schema.Target, error) {
 	ids := []string{}
 	for _, id := range instanceIds {
@@ -112,26 +182,11 @@ func (this *AWSResolver) resolveEC2Instances(ctx context.Context, instanceIds ..
 }
 
 func (this *AWSResolver) resolveEC2InstancesWithInput(ctx context.Context, input *opsee_aws_ec2.DescribeInstancesInput) ([]*schema.Target, error) {
-	timestamp := &opsee_types.Timestamp{}
-	timestamp.Scan(time.Now().UTC().Add(DefaultResponseCacheTTL * -1))
-	resp, err := this.BezosClient.Get(
-		ctx,
-		&opsee.BezosRequest{
-			User:   this.User,
-			Region: this.Region,
-			VpcId:  this.VpcId,
-			MaxAge: timestamp,
-			Input:  &opsee.BezosRequest_Ec2_DescribeInstancesInput{input},
-		})
+	output, err := this.backend().DescribeInstances(ctx, input)
 	if err != nil {
 		return nil, err
 	}
 
-	output := resp.GetEc2_DescribeInstancesOutput()
-	if output == nil {
-		return nil, fmt.Errorf("error decoding aws response")
-	}
-
 	var targets []*schema.Target
 	for _, res := range output.Reservations {
 		for _, instance := range res.Instances {
@@ -156,26 +211,11 @@ func (this *AWSResolver) resolveASGs(ctx context.Context, asgNames ...string) ([
 		AutoScalingGroupNames: names,
 	}
 
-	maxAge := &opsee_types.Timestamp{}
-	maxAge.Scan(time.Now().UTC().Add(DefaultResponseCacheTTL * -1))
-	resp, err := this.BezosClient.Get(
-		ctx,
-		&opsee.BezosRequest{
-			User:   this.User,
-			Region: this.Region,
-			VpcId:  this.VpcId,
-			MaxAge: maxAge,
-			Input:  &opsee.BezosRequest_Autoscaling_DescribeAutoScalingGroupsInput{input},
-		})
+	output, err := this.backend().DescribeAutoScalingGroups(ctx, input)
 	if err != nil {
 		return nil, err
 	}
 
-	output := resp.GetAutoscaling_DescribeAutoScalingGroupsOutput()
-	if output == nil {
-		return nil, fmt.Errorf("error decoding aws response")
-	}
-
 	instanceIds := []string{}
 	for _, gr := range output.AutoScalingGroups {
 		for _, instance := range gr.Instances {
@@ -197,24 +237,12 @@ func (this *AWSResolver) resolveELBs(ctx context.Context, elbNames ...string) ([
 		LoadBalancerNames: names,
 	}
 
-	maxAge := &opsee_types.Timestamp{}
-	maxAge.Scan(time.Now().UTC().Add(DefaultResponseCacheTTL * -1))
-	resp, err := this.BezosClient.Get(
-		ctx,
-		&opsee.BezosRequest{
-			User:   this.User,
-			Region: this.Region,
-			VpcId:  this.VpcId,
-			MaxAge: maxAge,
-			Input:  &opsee.BezosRequest_Elb_DescribeLoadBalancersInput{input},
-		})
+	output, err := this.backend().DescribeLoadBalancers(ctx, input)
 	if err != nil {
 		return nil, err
 	}
-
-	output := resp.GetElb_DescribeLoadBalancersOutput()
-	if output == nil {
-		return nil, err
+	if len(output.LoadBalancerDescriptions) == 0 {
+		return nil, fmt.Errorf("LoadBalancer not found: %s", strings.Join(names, ", "))
 	}
 
 	elb := output.LoadBalancerDescriptions[0]
@@ -235,26 +263,11 @@ func (this *AWSResolver) resolveDBInstance(ctx context.Context, instanceId strin
 		DBInstanceIdentifier: aws.String(instanceId),
 	}
 
-	maxAge := &opsee_types.Timestamp{}
-	maxAge.Scan(time.Now().UTC().Add(DefaultResponseCacheTTL * -1))
-	resp, err := this.BezosClient.Get(
-		ctx,
-		&opsee.BezosRequest{
-			User:   this.User,
-			Region: this.Region,
-			VpcId:  this.VpcId,
-			MaxAge: maxAge,
-			Input:  &opsee.BezosRequest_Rds_DescribeDBInstancesInput{input},
-		})
+	output, err := this.backend().DescribeDBInstances(ctx, input)
 	if err != nil {
 		return nil, err
 	}
 
-	output := resp.GetRds_DescribeDBInstancesOutput()
-	if output == nil {
-		return nil, fmt.Errorf("error decoding aws response")
-	}
-
 	target := make([]*schema.Target, len(output.DBInstances))
 	for i, instance := range output.DBInstances {
 		target[i] = &schema.Target{
@@ -286,26 +299,11 @@ func (this *AWSResolver) resolveECSService(ctx context.Context, id string) ([]*s
 		ServiceName: aws.String(serviceName),
 	}
 
-	maxAge := &opsee_types.Timestamp{}
-	maxAge.Scan(time.Now().UTC().Add(DefaultResponseCacheTTL * -1))
-	bltResponse, err := this.BezosClient.Get(
-		ctx,
-		&opsee.BezosRequest{
-			User:   this.User,
-			Region: this.Region,
-			VpcId:  this.VpcId,
-			Input:  &opsee.BezosRequest_Ecs_ListTasksInput{ltInput},
-		},
-	)
+	ltOutput, err := this.backend().ListTasks(ctx, ltInput)
 	if err != nil {
 		return nil, err
 	}
 
-	ltOutput := bltResponse.GetEcs_ListTasksOutput()
-	if ltOutput == nil {
-		return nil, fmt.Errorf("error decoding aws response")
-	}
-
 	var tasks []string
 	for _, t := range ltOutput.TaskArns {
 		tasks = append(tasks, t)
@@ -316,24 +314,11 @@ func (this *AWSResolver) resolveECSService(ctx context.Context, id string) ([]*s
 		Cluster: aws.String(clusterName),
 	}
 
-	bdtResponse, err := this.BezosClient.Get(
-		ctx,
-		&opsee.BezosRequest{
-			User:   this.User,
-			Region: this.Region,
-			VpcId:  this.VpcId,
-			Input:  &opsee.BezosRequest_Ecs_DescribeTasksInput{dtInput},
-		},
-	)
+	bdtOutput, err := this.backend().DescribeTasks(ctx, dtInput)
 	if err != nil {
 		return nil, err
 	}
 
-	bdtOutput := bdtResponse.GetEcs_DescribeTasksOutput()
-	if bdtOutput == nil {
-		return nil, fmt.Errorf("error decoding aws response")
-	}
-
 	// DescribeTasksOutput has Tasks, each Task has:
 	// ContainerInstanceArn
 	// Containers[].NetworkBindings[].ContainerPort (which we match against to find host port)
@@ -359,24 +344,11 @@ func (this *AWSResolver) resolveECSService(ctx context.Context, id string) ([]*s
 		ContainerInstances: ci,
 	}
 
-	dciResp, err := this.BezosClient.Get(
-		ctx,
-		&opsee.BezosRequest{
-			User:   this.User,
-			Region: this.Region,
-			VpcId:  this.VpcId,
-			Input:  &opsee.BezosRequest_Ecs_DescribeContainerInstancesInput{dciInput},
-		},
-	)
+	dciOutput, err := this.backend().DescribeContainerInstances(ctx, dciInput)
 	if err != nil {
 		return nil, err
 	}
 
-	dciOutput := dciResp.GetEcs_DescribeContainerInstancesOutput()
-	if dciOutput == nil {
-		return nil, fmt.Errorf("error decoding aws response")
-	}
-
 	// ec2 instance id -> container instance arn (so we can look up the host port.)
 	instanceMap := map[string]string{}
 	instances := make([]string, len(ci))
@@ -488,6 +460,8 @@ func (this *AWSResolver) Resolve(ctx context.Context, target *schema.Target) ([]
 		return nil, fmt.Errorf("Invalid target: %s", target.String())
 	case "instance":
 		return this.resolveEC2Instances(ctx, target.Id)
+	case "tag":
+		return this.resolveTags(ctx, target.Id)
 	case "dbinstance":
 		return this.resolveDBInstance(ctx, target.Id)
 	case "ecs_service":
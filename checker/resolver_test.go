@@ -1,10 +1,12 @@
 package checker
 
 import (
+	"fmt"
 	"testing"
 
 	"github.com/opsee/basic/schema"
 	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/context"
 )
 
 func TestResolveHost(t *testing.T) {
@@ -38,3 +40,48 @@ func TestResolveHost(t *testing.T) {
 		assert.EqualValues("reddit.com", t.Name)
 	}
 }
+
+func TestParseTagFilters(t *testing.T) {
+	filters, err := parseTagFilters("service=payments, env = prod")
+	assert.NoError(t, err)
+	if assert.Len(t, filters, 2) {
+		assert.Equal(t, "tag:service", *filters[0].Name)
+		assert.Equal(t, []string{"payments"}, filters[0].Values)
+		assert.Equal(t, "tag:env", *filters[1].Name)
+		assert.Equal(t, []string{"prod"}, filters[1].Values)
+	}
+
+	for _, id := range []string{"", "service", "=payments", "service=payments,", "env=prod,env=staging"} {
+		_, err := parseTagFilters(id)
+		assert.Error(t, err, id)
+	}
+}
+
+func TestResolveTags(t *testing.T) {
+	fake := newFakeAWS(map[string]string{"DescribeInstances": describeInstancesResponse})
+	defer fake.Close()
+
+	resolver := &AWSResolver{VpcId: "vpc-1", Backend: fake.backend()}
+	targets, err := resolver.Resolve(context.Background(), &schema.Target{Type: "tag", Id: "service=payments,env=prod"})
+	assert.NoError(t, err)
+	assert.Equal(t, []*schema.Target{{Type: "instance", Id: "i-abc", Address: "10.0.0.1"}}, targets)
+
+	if assert.Len(t, fake.requests, 1) {
+		request := fake.requests[0]
+		filters := map[string]string{}
+		for i := 1; i <= 4; i++ {
+			filters[request.FormValue(fmt.Sprintf("Filter.%d.Name", i))] = request.FormValue(fmt.Sprintf("Filter.%d.Value.1", i))
+		}
+		assert.Equal(t, map[string]string{
+			"vpc-id":              "vpc-1",
+			"instance-state-name": "running",
+			"tag:service":         "payments",
+			"tag:env":             "prod",
+		}, filters)
+		assert.Empty(t, request.FormValue("InstanceId.1"))
+	}
+
+	_, err = resolver.Resolve(context.Background(), &schema.Target{Type: "tag", Id: "service"})
+	assert.Error(t, err)
+	assert.Len(t, fake.requests, 1)
+}
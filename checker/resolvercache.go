@@ -0,0 +1,234 @@
+package checker
+
+import (
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/opsee/basic/schema"
+	metrics "github.com/rcrowley/go-metrics"
+	"golang.org/x/net/context"
+)
+
+// ResolverCacheConfig controls how long resolved targets are kept by a
+// caching resolver.
+type ResolverCacheConfig struct {
+	// TTL is how long resolved targets are used without resolving them again.
+	// Zero disables the cache.
+	TTL time.Duration
+	// MaxStale is how long after TTL targets may still be used. They're
+	// refreshed in the background meanwhile, and kept if that fails, so that
+	// AWS or bezos being unavailable doesn't fail checks.
+	MaxStale time.Duration
+}
+
+// DefaultResolverCacheConfig is read when a caching resolver is created.
+var DefaultResolverCacheConfig = ResolverCacheConfig{
+	TTL:      30 * time.Second,
+	MaxStale: 10 * time.Minute,
+}
+
+// resolverRefreshTimeout bounds background refreshes, which aren't tied to
+// any check.
+const resolverRefreshTimeout = 30 * time.Second
+
+type resolverCacheEntry struct {
+	targets    []*schema.Target
+	resolved   time.Time
+	refreshing bool
+}
+
+// A resolverCall is a resolution of targets that aren't cached, which
+// concurrent misses for the same targets wait on instead of resolving them
+// again.
+type resolverCall struct {
+	done    chan struct{}
+	targets []*schema.Target
+	err     error
+	// abandoned is true if the call failed because its context was done.
+	abandoned bool
+}
+
+// A CachingResolver keeps the targets that its Resolver resolves to.
+type CachingResolver struct {
+	Resolver
+	config ResolverCacheConfig
+
+	sync.Mutex
+	entries   map[string]*resolverCacheEntry
+	calls     map[string]*resolverCall
+	lastPrune time.Time
+
+	registry metrics.Registry
+}
+
+// NewCachingResolver returns a resolver that caches what r resolves, as
+// configured by DefaultResolverCacheConfig. If the cache is disabled, it
+// returns r.
+func NewCachingResolver(r Resolver) Resolver {
+	if DefaultResolverCacheConfig.TTL <= 0 {
+		return r
+	}
+	return newCachingResolver(r, DefaultResolverCacheConfig)
+}
+
+func newCachingResolver(r Resolver, cfg ResolverCacheConfig) *CachingResolver {
+	c := &CachingResolver{
+		Resolver:  r,
+		config:    cfg,
+		entries:   make(map[string]*resolverCacheEntry),
+		calls:     make(map[string]*resolverCall),
+		lastPrune: time.Now(),
+		registry:  metrics.NewPrefixedChildRegistry(metricsRegistry, "resolver_cache."),
+	}
+	metrics.GetOrRegisterGauge("entries", c.registry)
+
+	return c
+}
+
+func resolverCacheKey(target *schema.Target) string {
+	return target.Type + "/" + target.Id + "/" + target.Name
+}
+
+// Resolve returns the cached targets for target if they're fresh, or stale
+// but within MaxStale, in which case they're refreshed in the background.
+// Otherwise it resolves them, once for all of the concurrent calls for the
+// same target.
+func (c *CachingResolver) Resolve(ctx context.Context, target *schema.Target) ([]*schema.Target, error) {
+	key := resolverCacheKey(target)
+	now := time.Now()
+
+	c.Lock()
+	c.prune(now)
+	entry, ok := c.entries[key]
+	if ok {
+		age := now.Sub(entry.resolved)
+		switch {
+		case age < c.config.TTL:
+			c.Unlock()
+			metrics.GetOrRegisterCounter("hit", c.registry).Inc(1)
+			return copyTargets(entry.targets), nil
+
+		case age < c.config.TTL+c.config.MaxStale:
+			if !entry.refreshing {
+				entry.refreshing = true
+				go c.refresh(key, target)
+			}
+			targets := entry.targets
+			c.Unlock()
+			metrics.GetOrRegisterCounter("stale", c.registry).Inc(1)
+			metrics.GetOrRegisterTimer("staleness", c.registry).Update(age - c.config.TTL)
+			return copyTargets(targets), nil
+		}
+	}
+
+	if call, ok := c.calls[key]; ok {
+		c.Unlock()
+		metrics.GetOrRegisterCounter("miss_shared", c.registry).Inc(1)
+
+		select {
+		case <-call.done:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+
+		// The call's context isn't ours, so if it ran out, try again.
+		if call.abandoned {
+			return c.Resolve(ctx, target)
+		}
+		if call.err != nil {
+			return nil, call.err
+		}
+		return copyTargets(call.targets), nil
+	}
+
+	call := &resolverCall{done: make(chan struct{})}
+	c.calls[key] = call
+	c.Unlock()
+
+	metrics.GetOrRegisterCounter("miss", c.registry).Inc(1)
+	call.targets, call.err = c.Resolver.Resolve(ctx, target)
+	call.abandoned = call.err != nil && ctx.Err() != nil
+
+	c.Lock()
+	if call.err == nil {
+		c.set(key, call.targets)
+	}
+	delete(c.calls, key)
+	c.Unlock()
+	close(call.done)
+
+	if call.err != nil {
+		return nil, call.err
+	}
+	return copyTargets(call.targets), nil
+}
+
+// refresh resolves the target again, keeping its stale targets if that
+// fails.
+func (c *CachingResolver) refresh(key string, target *schema.Target) {
+	ctx, cancel := context.WithTimeout(context.Background(), resolverRefreshTimeout)
+	defer cancel()
+
+	metrics.GetOrRegisterCounter("refresh", c.registry).Inc(1)
+	targets, err := c.Resolver.Resolve(ctx, target)
+	if err != nil {
+		c.Lock()
+		if entry, ok := c.entries[key]; ok {
+			entry.refreshing = false
+		}
+		c.Unlock()
+
+		log.WithError(err).WithField("target", key).Warn("Couldn't refresh resolved targets, using stale ones.")
+		metrics.GetOrRegisterCounter("refresh_error", c.registry).Inc(1)
+		return
+	}
+
+	c.store(key, targets)
+}
+
+func (c *CachingResolver) store(key string, targets []*schema.Target) {
+	c.Lock()
+	defer c.Unlock()
+	c.set(key, targets)
+}
+
+// set caches the targets. The caller must hold the lock.
+func (c *CachingResolver) set(key string, targets []*schema.Target) {
+	c.entries[key] = &resolverCacheEntry{
+		targets:  copyTargets(targets),
+		resolved: time.Now(),
+	}
+	metrics.GetOrRegisterGauge("entries", c.registry).Update(int64(len(c.entries)))
+}
+
+// prune forgets entries too stale to be used, so that targets of deleted
+// checks don't stay cached. The caller must hold the lock.
+func (c *CachingResolver) prune(now time.Time) {
+	if now.Sub(c.lastPrune) < c.config.TTL {
+		return
+	}
+	c.lastPrune = now
+
+	for key, entry := range c.entries {
+		if !entry.refreshing && now.Sub(entry.resolved) >= c.config.TTL+c.config.MaxStale {
+			delete(c.entries, key)
+		}
+	}
+	metrics.GetOrRegisterGauge("entries", c.registry).Update(int64(len(c.entries)))
+}
+
+// copyTargets copies targets, so that the cache's own can't be modified by
+// the checks they're given to.
+func copyTargets(targets []*schema.Target) []*schema.Target {
+	if targets == nil {
+		return nil
+	}
+
+	copied := make([]*schema.Target, len(targets))
+	for i, t := range targets {
+		target := *t
+		copied[i] = &target
+	}
+	return copied
+}
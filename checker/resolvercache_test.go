@@ -0,0 +1,241 @@
+package checker
+
+import (
+	"errors"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/opsee/basic/schema"
+	metrics "github.com/rcrowley/go-metrics"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/context"
+)
+
+// countingResolver resolves every target to a single host, whose address is
+// the number of times it's been asked, unless it's been told to fail. If it
+// has a wait channel, it doesn't answer until that's closed.
+type countingResolver struct {
+	sync.Mutex
+	calls int
+	err   error
+	wait  chan struct{}
+}
+
+func (r *countingResolver) Resolve(ctx context.Context, target *schema.Target) ([]*schema.Target, error) {
+	if r.wait != nil {
+		select {
+		case <-r.wait:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+
+	r.Lock()
+	defer r.Unlock()
+
+	r.calls++
+	if r.err != nil {
+		return nil, r.err
+	}
+	return []*schema.Target{{Type: "instance", Id: target.Id, Address: strconv.Itoa(r.calls)}}, nil
+}
+
+func (r *countingResolver) setErr(err error) {
+	r.Lock()
+	defer r.Unlock()
+	r.err = err
+}
+
+func (r *countingResolver) count() int {
+	r.Lock()
+	defer r.Unlock()
+	return r.calls
+}
+
+func counterCount(c *CachingResolver, name string) int64 {
+	return metrics.GetOrRegisterCounter(name, c.registry).Count()
+}
+
+// eventually waits up to a second for condition to be true.
+func eventually(t *testing.T, condition func() bool) {
+	deadline := time.Now().Add(time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatal("condition not met")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// waitForCalls waits for the resolver to have been called n times.
+func waitForCalls(t *testing.T, r *countingResolver, n int) {
+	eventually(t, func() bool { return r.count() >= n })
+}
+
+func TestCachingResolverHit(t *testing.T) {
+	resolver := &countingResolver{}
+	cache := newCachingResolver(resolver, ResolverCacheConfig{TTL: time.Minute, MaxStale: time.Minute})
+	target := &schema.Target{Type: "sg", Id: "sg-1"}
+	hits, misses := counterCount(cache, "hit"), counterCount(cache, "miss")
+
+	targets, err := cache.Resolve(context.Background(), target)
+	assert.NoError(t, err)
+	assert.Equal(t, "1", targets[0].Address)
+
+	// Changing what's returned doesn't change what's cached.
+	targets[0].Address = "changed"
+
+	targets, err = cache.Resolve(context.Background(), target)
+	assert.NoError(t, err)
+	assert.Equal(t, "1", targets[0].Address)
+	assert.Equal(t, 1, resolver.count())
+	assert.EqualValues(t, 1, counterCount(cache, "hit")-hits)
+	assert.EqualValues(t, 1, counterCount(cache, "miss")-misses)
+
+	// Targets are cached separately.
+	targets, err = cache.Resolve(context.Background(), &schema.Target{Type: "sg", Id: "sg-2"})
+	assert.NoError(t, err)
+	assert.Equal(t, "sg-2", targets[0].Id)
+	assert.Equal(t, 2, resolver.count())
+}
+
+func TestCachingResolverRefreshesStaleTargets(t *testing.T) {
+	resolver := &countingResolver{}
+	cache := newCachingResolver(resolver, ResolverCacheConfig{TTL: 20 * time.Millisecond, MaxStale: time.Minute})
+	target := &schema.Target{Type: "sg", Id: "sg-1"}
+	stale := counterCount(cache, "stale")
+
+	_, err := cache.Resolve(context.Background(), target)
+	assert.NoError(t, err)
+	time.Sleep(30 * time.Millisecond)
+
+	// The stale targets are used while they're refreshed.
+	targets, err := cache.Resolve(context.Background(), target)
+	assert.NoError(t, err)
+	assert.Equal(t, "1", targets[0].Address)
+	assert.EqualValues(t, 1, counterCount(cache, "stale")-stale)
+	waitForCalls(t, resolver, 2)
+
+	eventually(t, func() bool {
+		targets, err := cache.Resolve(context.Background(), target)
+		return err == nil && targets[0].Address == "2"
+	})
+	assert.Equal(t, 2, resolver.count())
+}
+
+func TestCachingResolverServesStaleOnError(t *testing.T) {
+	resolver := &countingResolver{}
+	cache := newCachingResolver(resolver, ResolverCacheConfig{TTL: 20 * time.Millisecond, MaxStale: 80 * time.Millisecond})
+	target := &schema.Target{Type: "sg", Id: "sg-1"}
+	refreshErrors := counterCount(cache, "refresh_error")
+
+	_, err := cache.Resolve(context.Background(), target)
+	assert.NoError(t, err)
+
+	resolver.setErr(errors.New("bezos is unreachable"))
+	time.Sleep(30 * time.Millisecond)
+
+	targets, err := cache.Resolve(context.Background(), target)
+	assert.NoError(t, err)
+	assert.Equal(t, "1", targets[0].Address)
+	waitForCalls(t, resolver, 2)
+
+	eventually(t, func() bool {
+		return counterCount(cache, "refresh_error")-refreshErrors == 1
+	})
+
+	// Another refresh is tried after a failed one.
+	targets, err = cache.Resolve(context.Background(), target)
+	assert.NoError(t, err)
+	assert.Equal(t, "1", targets[0].Address)
+	waitForCalls(t, resolver, 3)
+
+	// Targets too stale to use are resolved again.
+	time.Sleep(100 * time.Millisecond)
+	_, err = cache.Resolve(context.Background(), target)
+	assert.EqualError(t, err, "bezos is unreachable")
+}
+
+func TestCachingResolverSharesMisses(t *testing.T) {
+	resolver := &countingResolver{wait: make(chan struct{})}
+	cache := newCachingResolver(resolver, ResolverCacheConfig{TTL: time.Minute, MaxStale: time.Minute})
+	target := &schema.Target{Type: "sg", Id: "sg-1"}
+	shared := counterCount(cache, "miss_shared")
+
+	const n = 20
+	var wg sync.WaitGroup
+	addresses := make([]string, n)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			targets, err := cache.Resolve(context.Background(), target)
+			if assert.NoError(t, err) {
+				addresses[i] = targets[0].Address
+			}
+		}(i)
+	}
+
+	eventually(t, func() bool {
+		return counterCount(cache, "miss_shared")-shared == n-1
+	})
+	close(resolver.wait)
+	wg.Wait()
+
+	assert.Equal(t, 1, resolver.count())
+	for _, address := range addresses {
+		assert.Equal(t, "1", address)
+	}
+}
+
+func TestCachingResolverRetriesAbandonedMiss(t *testing.T) {
+	resolver := &countingResolver{wait: make(chan struct{})}
+	cache := newCachingResolver(resolver, ResolverCacheConfig{TTL: time.Minute, MaxStale: time.Minute})
+	target := &schema.Target{Type: "sg", Id: "sg-1"}
+	shared := counterCount(cache, "miss_shared")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	abandoned := make(chan error, 1)
+	go func() {
+		_, err := cache.Resolve(ctx, target)
+		abandoned <- err
+	}()
+	eventually(t, func() bool {
+		cache.Lock()
+		defer cache.Unlock()
+		return len(cache.calls) == 1
+	})
+
+	resolved := make(chan []*schema.Target, 1)
+	go func() {
+		targets, err := cache.Resolve(context.Background(), target)
+		assert.NoError(t, err)
+		resolved <- targets
+	}()
+	eventually(t, func() bool {
+		return counterCount(cache, "miss_shared")-shared == 1
+	})
+
+	// The waiting call resolves the target itself once the first gives up.
+	cancel()
+	assert.Equal(t, context.Canceled, <-abandoned)
+	close(resolver.wait)
+	targets := <-resolved
+	assert.Equal(t, "1", targets[0].Address)
+	assert.Equal(t, 1, resolver.count())
+}
+
+func TestNewCachingResolverDisabled(t *testing.T) {
+	defer func(cfg ResolverCacheConfig) {
+		DefaultResolverCacheConfig = cfg
+	}(DefaultResolverCacheConfig)
+
+	resolver := &countingResolver{}
+	DefaultResolverCacheConfig.TTL = 0
+	assert.Equal(t, resolver, NewCachingResolver(resolver))
+
+	DefaultResolverCacheConfig.TTL = time.Second
+	assert.IsType(t, &CachingResolver{}, NewCachingResolver(resolver))
+}
@@ -0,0 +1,195 @@
+package checker
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/opsee/basic/schema"
+	"github.com/opsee/bastion/errs"
+	"github.com/opsee/bastion/netutil"
+	"golang.org/x/net/context"
+)
+
+const (
+	DefaultRetryInitialInterval = 500 * time.Millisecond
+	DefaultRetryMaxInterval     = 5 * time.Second
+	DefaultRetryMultiplier      = 1.5
+)
+
+// attemptFunc makes a single attempt at a request.
+type attemptFunc func(context.Context) *Response
+
+// failureFunc returns the reason an attempt failed, or nil if it succeeded,
+// and whether the attempt may be retried.
+type failureFunc func(*Response) (error, bool)
+
+// retryBackOff returns the back off between attempts described by policy.
+func retryBackOff(policy *schema.RetryPolicy) *netutil.ExponentialBackOff {
+	b := &netutil.ExponentialBackOff{
+		InitialInterval:     DefaultRetryInitialInterval,
+		RandomizationFactor: netutil.DefaultRandomizationFactor,
+		Multiplier:          DefaultRetryMultiplier,
+		MaxInterval:         DefaultRetryMaxInterval,
+		// Retries are bounded by the context's deadline instead.
+		MaxElapsedTime: 0,
+		Clock:          netutil.SystemClock,
+	}
+
+	if policy.InitialInterval > 0 {
+		b.InitialInterval = time.Duration(policy.InitialInterval) * time.Millisecond
+	}
+	if policy.MaxInterval > 0 {
+		b.MaxInterval = time.Duration(policy.MaxInterval) * time.Millisecond
+	}
+	if policy.Multiplier >= 1 {
+		b.Multiplier = policy.Multiplier
+	}
+
+	b.Reset()
+	return b
+}
+
+// withRetries makes attempts at a request until one succeeds, one fails in a
+// way that may not be retried, policy's attempts are used up, or the next
+// attempt would start after ctx's deadline. The last attempt's response is
+// returned, annotated with the number of attempts made and their errors.
+func withRetries(ctx context.Context, policy *schema.RetryPolicy, attempt attemptFunc, failure failureFunc) *Response {
+	maxAttempts := 1
+	if policy != nil && policy.Attempts > 1 {
+		maxAttempts = int(policy.Attempts)
+	}
+
+	var (
+		backOff     *netutil.ExponentialBackOff
+		response    *Response
+		attemptErrs []string
+	)
+
+	for {
+		response = attempt(ctx)
+
+		err, retryable := failure(response)
+		if err != nil {
+			attemptErrs = append(attemptErrs, err.Error())
+		} else {
+			attemptErrs = append(attemptErrs, "")
+		}
+
+		if err == nil || !retryable || len(attemptErrs) >= maxAttempts || ctx.Err() != nil {
+			break
+		}
+
+		if backOff == nil {
+			backOff = retryBackOff(policy)
+		}
+		wait := backOff.NextBackOff()
+		if deadline, ok := ctx.Deadline(); ok && time.Now().Add(wait).After(deadline) {
+			log.WithError(err).Debug("Not retrying, the next attempt would start after the deadline.")
+			break
+		}
+
+		log.WithError(err).Debugf("Attempt %d failed, retrying in %s.", len(attemptErrs), wait)
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+		}
+		timer.Stop()
+
+		if ctx.Err() != nil {
+			break
+		}
+		if err := acquireRetry(ctx); err != nil {
+			log.WithError(err).Debug("Not retrying, the target's limits don't allow it.")
+			break
+		}
+	}
+
+	response.Attempts = len(attemptErrs)
+	response.AttemptErrors = attemptErrs
+	return response
+}
+
+// errorClass sorts errors into the kinds named by RetryPolicy's
+// retryable_errors.
+func errorClass(err error) string {
+	switch errs.Classify(err) {
+	case errs.Timeout:
+		return "timeout"
+	case errs.DNS:
+		return "dns"
+	case errs.ConnectionRefused, errs.Connection:
+		return "connection"
+	case errs.TLS:
+		return "tls"
+	case errs.RateLimited:
+		return "rate_limited"
+	}
+
+	return "other"
+}
+
+// retryableError returns true if policy allows err to be retried.
+func retryableError(policy *schema.RetryPolicy, err error) bool {
+	if err == context.Canceled {
+		return false
+	}
+
+	if policy == nil || len(policy.RetryableErrors) == 0 {
+		return true
+	}
+
+	class := errorClass(err)
+	for _, e := range policy.RetryableErrors {
+		if e == class {
+			return true
+		}
+	}
+
+	return false
+}
+
+// retryableStatusCode returns true if policy allows a response with the
+// given status code to be retried.
+func retryableStatusCode(policy *schema.RetryPolicy, code int32) bool {
+	if policy == nil {
+		return false
+	}
+
+	status := strconv.Itoa(int(code))
+	for _, c := range policy.RetryableStatusCodes {
+		c = strings.ToLower(c)
+		if c == status || (len(c) == 3 && strings.HasSuffix(c, "xx") && c[0] == status[0]) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// failure implements failureFunc for HTTP requests.
+func (r *HTTPRequest) failure(response *Response) (error, bool) {
+	if response.Error != nil {
+		return response.Error, retryableError(r.RetryPolicy, response.Error)
+	}
+
+	if reply, ok := response.Response.(*schema.CheckResponse_HttpResponse); ok {
+		if code := reply.HttpResponse.Code; retryableStatusCode(r.RetryPolicy, code) {
+			return fmt.Errorf("Received retryable status code %d.", code), true
+		}
+	}
+
+	return nil, false
+}
+
+// failure implements failureFunc for CloudWatch requests. A response has an
+// error if any of its metrics could not be fetched.
+func (this *CloudWatchRequest) failure(response *Response) (error, bool) {
+	if response.Error != nil {
+		return response.Error, retryableError(this.RetryPolicy, response.Error)
+	}
+	return nil, false
+}
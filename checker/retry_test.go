@@ -0,0 +1,149 @@
+package checker
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/opsee/basic/schema"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/context"
+)
+
+// flakyServer responds with failCode to the first failures requests.
+func flakyServer(failures int, failCode int) (*httptest.Server, *int) {
+	requests := 0
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if requests <= failures {
+			w.WriteHeader(failCode)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	})), &requests
+}
+
+func retryTestWork(ctx context.Context, request *HTTPRequest) *Response {
+	worker := NewHTTPWorker(make(chan Worker, 1))
+	task := worker.Work(ctx, &Task{Type: httpWorkerTaskType, Request: request})
+	return task.Response
+}
+
+func TestRetryStatusCode(t *testing.T) {
+	ts, requests := flakyServer(2, http.StatusServiceUnavailable)
+	defer ts.Close()
+
+	response := retryTestWork(context.Background(), &HTTPRequest{
+		Method: "GET",
+		URL:    ts.URL,
+		RetryPolicy: &schema.RetryPolicy{
+			Attempts:             3,
+			InitialInterval:      1,
+			RetryableStatusCodes: []string{"5xx"},
+		},
+	})
+
+	assert.NoError(t, response.Error)
+	assert.EqualValues(t, 200, response.Response.(*schema.CheckResponse_HttpResponse).HttpResponse.Code)
+	assert.Equal(t, 3, *requests)
+	assert.Equal(t, 3, response.Attempts)
+	assert.Equal(t, []string{"Received retryable status code 503.", "Received retryable status code 503.", ""}, response.AttemptErrors)
+}
+
+func TestRetryNotRetryable(t *testing.T) {
+	ts, requests := flakyServer(2, http.StatusNotFound)
+	defer ts.Close()
+
+	response := retryTestWork(context.Background(), &HTTPRequest{
+		Method: "GET",
+		URL:    ts.URL,
+		RetryPolicy: &schema.RetryPolicy{
+			Attempts:             3,
+			InitialInterval:      1,
+			RetryableStatusCodes: []string{"503"},
+		},
+	})
+
+	assert.EqualValues(t, 404, response.Response.(*schema.CheckResponse_HttpResponse).HttpResponse.Code)
+	assert.Equal(t, 1, *requests)
+	assert.Equal(t, 1, response.Attempts)
+}
+
+func TestRetryNoPolicy(t *testing.T) {
+	ts, requests := flakyServer(1, http.StatusServiceUnavailable)
+	defer ts.Close()
+
+	response := retryTestWork(context.Background(), &HTTPRequest{Method: "GET", URL: ts.URL})
+
+	assert.EqualValues(t, 503, response.Response.(*schema.CheckResponse_HttpResponse).HttpResponse.Code)
+	assert.Equal(t, 1, *requests)
+	assert.Equal(t, []string{""}, response.AttemptErrors)
+}
+
+func TestRetryTargetLimits(t *testing.T) {
+	ts, requests := flakyServer(3, http.StatusServiceUnavailable)
+	defer ts.Close()
+	request := &HTTPRequest{
+		Method: "GET",
+		URL:    ts.URL,
+		RetryPolicy: &schema.RetryPolicy{
+			Attempts:             3,
+			InitialInterval:      1,
+			RetryableStatusCodes: []string{"5xx"},
+		},
+	}
+
+	// With a burst of 2, the first retry uses the second token and the next
+	// waits half a second for another.
+	l := newTargetLimiter(TargetLimits{RequestsPerSecond: 2})
+	done, err := l.acquire(context.Background(), request.targetAddress())
+	assert.NoError(t, err)
+	start := time.Now()
+	response := retryTestWork(withTargetLimit(context.Background(), l, request.targetAddress()), request)
+	done()
+	assert.Equal(t, 3, response.Attempts)
+	assert.True(t, time.Since(start) >= 400*time.Millisecond)
+
+	// Retries over the limits aren't made if the limiter fails fast.
+	*requests = 0
+	l = newTargetLimiter(TargetLimits{RequestsPerSecond: 1, FailFast: true})
+	done, err = l.acquire(context.Background(), request.targetAddress())
+	assert.NoError(t, err)
+	response = retryTestWork(withTargetLimit(context.Background(), l, request.targetAddress()), request)
+	done()
+	assert.Equal(t, 1, response.Attempts)
+	assert.Equal(t, 1, *requests)
+}
+
+func TestRetryDeadline(t *testing.T) {
+	ts, requests := flakyServer(5, http.StatusServiceUnavailable)
+	defer ts.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	t0 := time.Now()
+	response := retryTestWork(ctx, &HTTPRequest{
+		Method: "GET",
+		URL:    ts.URL,
+		RetryPolicy: &schema.RetryPolicy{
+			Attempts:             5,
+			InitialInterval:      5000,
+			RetryableStatusCodes: []string{"503"},
+		},
+	})
+
+	assert.True(t, time.Since(t0) < time.Second, "retries should not wait past the deadline")
+	assert.Equal(t, 1, *requests)
+	assert.Equal(t, 1, response.Attempts)
+}
+
+func TestRetryableErrors(t *testing.T) {
+	policy := &schema.RetryPolicy{RetryableErrors: []string{"timeout"}}
+	assert.True(t, retryableError(policy, context.DeadlineExceeded))
+	assert.False(t, retryableError(policy, errors.New("dial tcp 10.0.0.1:80: connection refused")))
+	assert.True(t, retryableError(&schema.RetryPolicy{}, errors.New("anything")))
+	assert.False(t, retryableError(&schema.RetryPolicy{}, context.Canceled))
+}
@@ -2,9 +2,11 @@ package checker
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"reflect"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	log "github.com/Sirupsen/logrus"
@@ -12,6 +14,7 @@ import (
 	"github.com/nsqio/go-nsq"
 	"github.com/opsee/basic/schema"
 	"github.com/opsee/bastion/config"
+	"github.com/opsee/bastion/errs"
 	opsee_types "github.com/opsee/protobuf/opseeproto/types"
 	metrics "github.com/rcrowley/go-metrics"
 	"golang.org/x/net/context"
@@ -25,27 +28,56 @@ type NSQRunnerConfig struct {
 	ConsumerNsqdHost    string
 	ProducerNsqdHost    string
 	MaxHandlers         int
+	// CheckTypes limits the check types an NSQRunner consumes to a subset of
+	// those its Runner handles.
+	CheckTypes []string
+	// MaxHandlersPerType overrides MaxHandlers for individual check types.
+	MaxHandlersPerType map[string]int
+	// TargetTransitionQueueName is the topic that TargetStateTransitions are
+	// published to. They aren't published if it's empty.
+	TargetTransitionQueueName string
+}
+
+// maxHandlers returns the maximum number of checks of the named type that may
+// be run concurrently.
+func (cfg *NSQRunnerConfig) maxHandlers(checkType string) int {
+	if n, ok := cfg.MaxHandlersPerType[checkType]; ok && n > 0 {
+		return n
+	}
+	return cfg.MaxHandlers
 }
 
 type NSQRunner struct {
-	runner   *Runner
-	config   *NSQRunnerConfig
-	producer *nsq.Producer
-	consumer *nsq.Consumer
+	runner    *Runner
+	config    *NSQRunnerConfig
+	producer  *nsq.Producer
+	consumers []*nsq.Consumer
+
+	// ctx is the parent of every check's context, and is cancelled if checks
+	// are still running once a graceful stop's drain timeout has passed.
+	ctx    context.Context
+	cancel context.CancelFunc
+	// draining is set once a graceful stop has begun.
+	draining int32
+
+	targets *targetTracker
 }
 
+// NewNSQRunner returns an NSQRunner that runs every check type handled by
+// runner (and listed in the config's CheckTypes, if any). Each check type is
+// consumed from its own topic (see CheckTopic) by its own consumer, so each
+// type's concurrency is limited independently.
 func NewNSQRunner(runner *Runner, cfg *NSQRunnerConfig) (*NSQRunner, error) {
-	consumerConfig := nsq.NewConfig()
-	// This will effectively be the maximum number of simultaneous Checks that we can
-	// run. Keep in mind that each Check MAY yield many requests, and there are only
-	// MaxRoutinesPerWorkerType workers per check type.
-	consumerConfig.MaxInFlight = 4
-
-	consumer, err := nsq.NewConsumer(cfg.ConsumerQueueName, cfg.ConsumerChannelName, consumerConfig)
-	if err != nil {
-		return nil, err
+	checkTypes := runner.CheckTypes()
+	if len(cfg.CheckTypes) > 0 {
+		checkTypes = []string{}
+		for _, t := range cfg.CheckTypes {
+			if !runner.handlesType(t) {
+				return nil, fmt.Errorf("Runner does not handle %s checks.", t)
+			}
+			checkTypes = append(checkTypes, t)
+		}
 	}
-	log.Debugf("NSQRunner consuming on queue %s, channel %s", cfg.ConsumerQueueName, cfg.ConsumerChannelName)
 
 	producerConfig := nsq.NewConfig()
 	producerConfig.MaxInFlight = 2
@@ -56,6 +88,14 @@ func NewNSQRunner(runner *Runner, cfg *NSQRunnerConfig) (*NSQRunner, error) {
 	log.Debugf("NSQRunner producing on queue %s", cfg.ProducerQueueName)
 
 	registry := metrics.NewPrefixedChildRegistry(metricsRegistry, "runner.")
+
+	nsqRunner := &NSQRunner{
+		config:   cfg,
+		runner:   runner,
+		producer: producer,
+		targets:  newTargetTracker(DefaultFlapConfig),
+	}
+	nsqRunner.ctx, nsqRunner.cancel = context.WithCancel(context.Background())
 	bastionCustomerId := config.GetConfig().CustomerId
 
 	bastionRegion := ""
@@ -63,7 +103,16 @@ func NewNSQRunner(runner *Runner, cfg *NSQRunnerConfig) (*NSQRunner, error) {
 		bastionRegion = metaData.Region
 	}
 
-	consumer.AddConcurrentHandlers(nsq.HandlerFunc(func(m *nsq.Message) error {
+	handler := nsq.HandlerFunc(func(m *nsq.Message) error {
+		// Messages that arrive after a graceful stop has begun are put back for
+		// another runner.
+		if atomic.LoadInt32(&nsqRunner.draining) == 1 {
+			m.DisableAutoResponse()
+			m.RequeueWithoutBackoff(0)
+			metrics.GetOrRegisterCounter("nsq_messages_requeued", registry).Inc(1)
+			return nil
+		}
+
 		checkWithTargets := &schema.CheckTargets{}
 		if err := proto.Unmarshal(m.Body, checkWithTargets); err != nil {
 			log.WithError(err).Errorf("Error decoding checkWithTargets: %s", string(m.Body))
@@ -72,18 +121,34 @@ func NewNSQRunner(runner *Runner, cfg *NSQRunnerConfig) (*NSQRunner, error) {
 
 		check := checkWithTargets.Check
 
+		// Checks are routed by type, so this only happens if a check was
+		// published to the wrong topic.
+		if !runner.Handles(check) {
+			log.WithFields(log.Fields{"check_id": check.Id, "type": CheckTypeName(check)}).Error("Received a check of a type this runner does not handle.")
+			return nil
+		}
+
+		ranAt := time.Now()
 		timestamp := &opsee_types.Timestamp{}
-		timestamp.Scan(time.Now())
+		timestamp.Scan(ranAt)
 
 		result := &schema.CheckResult{
-			CustomerId: check.CustomerId,
-			BastionId:  config.GetConfig().BastionId,
-			CheckId:    check.Id,
-			CheckName:  check.Name,
-			Target:     check.Target,
-			Timestamp:  timestamp,
-			Version:    BastionProtoVersion,
-			Region:     bastionRegion,
+			CustomerId:        check.CustomerId,
+			BastionId:         config.GetConfig().BastionId,
+			CheckId:           check.Id,
+			CheckName:         check.Name,
+			Target:            check.Target,
+			Timestamp:         timestamp,
+			Version:           BastionProtoVersion,
+			Region:            bastionRegion,
+			AggregationPolicy: check.AggregationPolicy,
+		}
+
+		// The check still runs while a check it depends on is failing, but its
+		// result is marked so that it isn't alerted on.
+		if len(checkWithTargets.FailingParents) > 0 {
+			result.Suppressed = true
+			result.FailingParents = checkWithTargets.FailingParents
 		}
 
 		// Backward compatibility required.
@@ -91,43 +156,68 @@ func NewNSQRunner(runner *Runner, cfg *NSQRunnerConfig) (*NSQRunner, error) {
 			check.CustomerId = bastionCustomerId
 		}
 
+		var transitions []*schema.TargetStateTransition
+
 		// Handle a resolver error
 		if checkWithTargets.Targets == nil || len(checkWithTargets.Targets) == 0 {
-			result.Responses = []*schema.CheckResponse{&schema.CheckResponse{
-				Target: check.Target,
-				Error:  fmt.Sprintf("Could not resolve target: type=%s id=%s name=%s", check.Target.Type, check.Target.Id, check.Target.Name),
-			}}
+			err := errs.Errorf(errs.Resolution, "Could not resolve target: type=%s id=%s name=%s", check.Target.Type, check.Target.Id, check.Target.Name)
+			if checkWithTargets.Error != "" {
+				// Schedulers that predate error categories don't send one.
+				var cause error = errs.New(checkWithTargets.ErrorCategory, checkWithTargets.Error)
+				if checkWithTargets.ErrorCategory == "" {
+					cause = errs.Wrap(errors.New(checkWithTargets.Error), errs.Resolution)
+				}
+				err = errs.Wrapf(cause, "Could not resolve target: type=%s id=%s name=%s", check.Target.Type, check.Target.Id, check.Target.Name)
+			}
+			response := &schema.CheckResponse{Target: check.Target}
+			setResponseError(response, err)
+			result.Responses = []*schema.CheckResponse{response}
 		} else {
-			d, err := time.ParseDuration(fmt.Sprintf("%ds", check.Interval))
-
-			ctx, cancel := context.WithDeadline(context.Background(), time.Now().Add(d*2))
-
-			// A call to RunCheck is synchronous. Calling cancel() is not necessarily superfluous though.
-			responses, err := runner.RunCheck(ctx, check, checkWithTargets.Targets)
-			log.WithFields(log.Fields{"check_id": check.Id}).Debug("Running check.")
-			cancel()
-
-			if responses == nil {
-				log.WithFields(log.Fields{"check_id": check.Id}).Debug("skipping check.")
-				return nil
+			// The runner applies the check's timeout.
+			ctx, cancel := context.WithCancel(nsqRunner.ctx)
+
+			// Test checks and redelivered messages get their own dispatch lanes,
+			// so neither waits behind a backlog of scheduled checks.
+			switch {
+			case checkWithTargets.Test:
+				ctx = WithLane(ctx, LaneTest)
+			case m.Attempts > 1:
+				ctx = WithLane(ctx, LaneRetry)
 			}
 
+			// Responses are collected as each target finishes. Calling cancel() once
+			// they all have is not necessarily superfluous though.
+			log.WithFields(log.Fields{"check_id": check.Id}).Debug("Running check.")
+			stream, err := runner.StreamCheck(ctx, check, checkWithTargets.Targets)
 			if err != nil {
-				log.WithError(err).WithFields(log.Fields{"check": check}).Error("Error running check.")
-				result.Responses = []*schema.CheckResponse{&schema.CheckResponse{
-					Target: check.Target,
-					Error:  handleError(err),
-				}}
+				cancel()
+				log.WithError(err).WithFields(log.Fields{"check_id": check.Id}).Error("Error running check.")
+				response := &schema.CheckResponse{Target: check.Target}
+				setResponseError(response, err)
+				result.Responses = []*schema.CheckResponse{response}
 			} else {
-				// Determine if the CheckResult has its passing flag set.
-				passing := true
-				for _, response := range responses {
-					if !response.Passing {
-						passing = false
-					}
+				responses := []*schema.CheckResponse{}
+				for response := range stream.Responses {
+					log.WithFields(log.Fields{"check_id": check.Id, "target": response.Target, "passing": response.Passing}).Debug("Target finished.")
+					responses = append(responses, response)
 				}
+				cancel()
+
+				if stream.Err() != nil {
+					log.WithFields(log.Fields{"check_id": check.Id}).Debug("skipping check.")
+					return nil
+				}
+
+				// Determine if the CheckResult has its passing flag set.
 				result.Responses = responses
-				result.Passing = passing
+				result.Passing, result.PassRatio = aggregate(check.AggregationPolicy, responses)
+
+				// Test checks and suppressed results don't count towards their
+				// targets' histories, so a target that changed state while
+				// suppressed transitions once its parents recover.
+				if !checkWithTargets.Test && !result.Suppressed {
+					transitions = nsqRunner.targets.record(result, ranAt)
+				}
 			}
 		}
 
@@ -151,27 +241,114 @@ func NewNSQRunner(runner *Runner, cfg *NSQRunnerConfig) (*NSQRunner, error) {
 				"Version":    BastionProtoVersion}).Debug("Published result to queue %s", cfg.ProducerQueueName)
 		}
 
+		if cfg.TargetTransitionQueueName != "" {
+			for _, transition := range transitions {
+				msg, err := proto.Marshal(transition)
+				if err != nil {
+					log.WithError(err).Error("Error marshaling TargetStateTransition")
+					continue
+				}
+				if err := producer.Publish(cfg.TargetTransitionQueueName, msg); err != nil {
+					log.WithError(err).Error("Error publishing TargetStateTransition")
+				}
+			}
+		}
+
 		metrics.GetOrRegisterCounter("nsq_messages_handled", registry).Inc(1)
 		return nil
-	}), cfg.MaxHandlers)
+	})
 
-	err = consumer.ConnectToNSQD(cfg.ConsumerNsqdHost)
-	if err != nil {
-		return nil, err
+	for _, checkType := range checkTypes {
+		maxHandlers := cfg.maxHandlers(checkType)
+
+		consumerConfig := nsq.NewConfig()
+		// This will effectively be the maximum number of simultaneous Checks of this
+		// type that we can run. Keep in mind that each Check MAY yield many requests,
+		// and each request type has a limited pool of workers.
+		consumerConfig.MaxInFlight = maxHandlers
+
+		topic := CheckTopic(cfg.ConsumerQueueName, checkType)
+		consumer, err := nsq.NewConsumer(topic, cfg.ConsumerChannelName, consumerConfig)
+		if err != nil {
+			nsqRunner.Stop()
+			return nil, err
+		}
+		log.Debugf("NSQRunner consuming on queue %s, channel %s", topic, cfg.ConsumerChannelName)
+
+		consumer.AddConcurrentHandlers(handler, maxHandlers)
+		nsqRunner.consumers = append(nsqRunner.consumers, consumer)
+
+		if err := consumer.ConnectToNSQD(cfg.ConsumerNsqdHost); err != nil {
+			nsqRunner.Stop()
+			return nil, err
+		}
 	}
 
-	return &NSQRunner{
-		config:   cfg,
-		runner:   runner,
-		producer: producer,
-		consumer: consumer,
-	}, nil
+	return nsqRunner, nil
+}
+
+// ResizePools resizes the runner's worker pools, see Runner.ResizePools.
+func (r *NSQRunner) ResizePools(cfgs map[string]WorkerPoolConfig) error {
+	return r.runner.ResizePools(cfgs)
+}
+
+// ReloadWorkerPools resizes the runner's worker pools to the configs in
+// WorkerPoolConfigsFile, if it is set.
+func (r *NSQRunner) ReloadWorkerPools() {
+	if WorkerPoolConfigsFile == "" {
+		log.Warn("No worker pool configs file to reload.")
+		return
+	}
+
+	cfgs, err := LoadWorkerPoolConfigs(WorkerPoolConfigsFile)
+	if err == nil {
+		err = r.ResizePools(cfgs)
+	}
+	if err != nil {
+		log.WithError(err).Error("Couldn't resize worker pools.")
+		return
+	}
+	log.WithField("pools", cfgs).Info("Resized worker pools.")
 }
 
+// Stop stops consuming checks, waits for the checks that are running to
+// finish, and stops the producer.
 func (r *NSQRunner) Stop() {
-	r.consumer.Stop()
-	<-r.consumer.StopChan
+	for _, consumer := range r.consumers {
+		consumer.Stop()
+		<-consumer.StopChan
+	}
 	r.producer.Stop()
+	r.cancel()
+}
+
+// GracefulStop stops consuming checks and gives the checks that are running
+// until drainTimeout to finish and publish their results. Checks still running
+// after that are cancelled, and publish their results with errors. Messages
+// that were delivered but not yet started are requeued.
+func (r *NSQRunner) GracefulStop(drainTimeout time.Duration) {
+	atomic.StoreInt32(&r.draining, 1)
+	log.WithFields(log.Fields{"drain_timeout": drainTimeout}).Info("Draining runner.")
+
+	for _, consumer := range r.consumers {
+		consumer.Stop()
+	}
+
+	timer := time.NewTimer(drainTimeout)
+	defer timer.Stop()
+
+	for _, consumer := range r.consumers {
+		select {
+		case <-consumer.StopChan:
+		case <-timer.C:
+			log.Warn("Drain timeout exceeded, cancelling running checks.")
+			r.cancel()
+			<-consumer.StopChan
+		}
+	}
+
+	r.producer.Stop()
+	r.cancel()
 }
 
 // A Runner is responsible for running checks. Given a request for a check
@@ -186,7 +363,8 @@ type Runner struct {
 	checkType   interface{}
 }
 
-// NewRunner returns a runner associated with a particular resolver.
+// NewRunner returns a runner that handles checks of the same type as
+// checkType, e.g. &schema.HttpCheck{}. A nil checkType handles every type.
 func NewRunner(checkType interface{}) *Runner {
 	dispatcher := NewDispatcher()
 
@@ -205,10 +383,78 @@ func NewRunner(checkType interface{}) *Runner {
 	return r
 }
 
+// ResizePools sets the size and bounds of the worker pools for the given task
+// types, leaving the others as they are.
+func (r *Runner) ResizePools(cfgs map[string]WorkerPoolConfig) error {
+	for taskType, cfg := range cfgs {
+		if err := r.dispatcher.ResizePool(taskType, cfg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CheckTypes returns the names of the check types the runner handles.
+func (r *Runner) CheckTypes() []string {
+	switch r.checkType.(type) {
+	case nil:
+		return CheckTypes
+	case *schema.HttpCheck:
+		return []string{HTTPCheckType}
+	case *schema.CloudWatchCheck:
+		return []string{CloudWatchCheckType}
+	}
+	return []string{}
+}
+
+func (r *Runner) handlesType(checkType string) bool {
+	for _, t := range r.CheckTypes() {
+		if t == checkType {
+			return true
+		}
+	}
+	return false
+}
+
+// Handles returns true if the runner can run check.
+func (r *Runner) Handles(check *schema.Check) bool {
+	return r.handlesType(CheckTypeName(check))
+}
+
+// httpTargetAddress returns the host:port to connect to for an HTTP check
+// against target, the host name to present in requests (if any), and whether
+// TLS verification should be skipped.
+func httpTargetAddress(target *schema.Target, port int32) (address, host string, skipVerify bool) {
+	skipVerify = true
+
+	// special case host targets so that we may explicitly set host name in http requests
+	// and validate ssl certs
+	switch target.Type {
+	case "host", "external_host":
+		// target.Name is used to determine the hostname for TLS, since target.Id has been
+		// set to the IP address
+		host = target.Name
+		skipVerify = false
+	}
+
+	if strings.Contains(target.Address, ":") {
+		address = target.Address
+	} else {
+		address = fmt.Sprintf("%s:%d", target.Address, port)
+	}
+
+	return address, host, skipVerify
+}
+
 func (r *Runner) dispatch(ctx context.Context, check *schema.Check, targets []*schema.Target) (chan *Task, error) {
 	// If the Check submitted is invalid, RunCheck will return a single
 	// CheckResponse indicating that there was an error with the Check.
-	log.WithFields(log.Fields{"check": check}).Debug("dispatch check")
+	// Log the check id rather than the check itself, which may carry credentials.
+	log.WithFields(log.Fields{"check_id": check.Id}).Debug("dispatch check")
+
+	if checkType := CheckTypeName(check); checkType != "" && !r.handlesType(checkType) {
+		return nil, fmt.Errorf("Runner does not handle %s checks.", checkType)
+	}
 
 	tg := TaskGroup{}
 
@@ -219,15 +465,6 @@ func (r *Runner) dispatch(ctx context.Context, check *schema.Check, targets []*s
 		switch check.GetSpec().(type) {
 		case *schema.Check_HttpCheck:
 			typedCheck := check.GetHttpCheck()
-			_, ok := r.checkType.(*schema.HttpCheck)
-			if !ok {
-				return nil, nil
-			}
-			var (
-				host       string
-				address    string
-				skipVerify = true
-			)
 
 			log.WithFields(log.Fields{"target": target}).Debug("dispatch - dispatching for target")
 			if target.Address == "" {
@@ -235,22 +472,7 @@ func (r *Runner) dispatch(ctx context.Context, check *schema.Check, targets []*s
 				continue
 			}
 
-			// special case host targets so that we may explicitly set host name in http requests
-			// and validate ssl certs
-			switch target.Type {
-			case "host", "external_host":
-				// target.Name is used to determine the hostname for TLS, since target.Id has been
-				// set to the IP address
-				host = target.Name
-				skipVerify = false
-			}
-
-			if strings.Contains(target.Address, ":") {
-				address = target.Address
-			} else {
-				address = fmt.Sprintf("%s:%d", target.Address, typedCheck.Port)
-			}
-
+			address, host, skipVerify := httpTargetAddress(target, typedCheck.Port)
 			request = &HTTPRequest{
 				Method:             typedCheck.Verb,
 				URL:                fmt.Sprintf("%s://%s%s", typedCheck.Protocol, address, typedCheck.Path),
@@ -258,14 +480,39 @@ func (r *Runner) dispatch(ctx context.Context, check *schema.Check, targets []*s
 				Body:               typedCheck.Body,
 				Host:               host,
 				InsecureSkipVerify: skipVerify,
+				RedirectPolicy:     typedCheck.RedirectPolicy,
+				MaxRedirects:       int(typedCheck.MaxRedirects),
+				Auth:               typedCheck.Auth,
+				KeepAlive:          typedCheck.KeepAlive,
+				WebSocket:          typedCheck.Websocket,
+				RetryPolicy:        check.RetryPolicy,
+				Diagnostics:        typedCheck.Diagnostics,
+			}
+
+		case *schema.Check_HttpTransactionCheck:
+			txnCheck := check.GetHttpTransactionCheck()
+
+			log.WithFields(log.Fields{"target": target}).Debug("dispatch - dispatching for target")
+			if target.Address == "" {
+				log.WithFields(log.Fields{"target": target}).Error("Target missing address.")
+				continue
+			}
+			if len(txnCheck.Steps) == 0 {
+				log.Info("Refusing to create HttpTransactionCheck with 0 steps")
+				continue
+			}
+
+			address, host, skipVerify := httpTargetAddress(target, txnCheck.Port)
+			request = &HTTPTransactionRequest{
+				Protocol:           txnCheck.Protocol,
+				Address:            address,
+				Host:               host,
+				InsecureSkipVerify: skipVerify,
+				Steps:              txnCheck.Steps,
 			}
 
 		case *schema.Check_CloudwatchCheck:
 			cloudwatchCheck := check.GetCloudwatchCheck()
-			_, ok := r.checkType.(*schema.CloudWatchCheck)
-			if !ok {
-				return nil, nil
-			}
 			defaultResponseCacheTTL := time.Second * time.Duration(5)
 			log.WithFields(log.Fields{"target": target}).Debug("dispatch - dispatching for target")
 
@@ -285,6 +532,15 @@ func (r *Runner) dispatch(ctx context.Context, check *schema.Check, targets []*s
 				continue
 			}
 
+			user := &schema.User{
+				Id:         1,
+				Verified:   true,
+				Active:     true,
+				Email:      globalConfig.CustomerEmail,
+				Admin:      false,
+				CustomerId: check.CustomerId,
+			}
+
 			request = &CloudWatchRequest{
 				Target:                 target,
 				Metrics:                cloudwatchCheck.Metrics,
@@ -292,17 +548,18 @@ func (r *Runner) dispatch(ctx context.Context, check *schema.Check, targets []*s
 				StatisticsPeriod:       CloudWatchStatisticsPeriod,
 				Statistics:             []string{"Average"},
 				Namespace:              cloudwatchCheck.Metrics[0].Namespace,
-				User: &schema.User{
-					Id:         1,
-					Verified:   true,
-					Active:     true,
-					Email:      globalConfig.CustomerEmail,
-					Admin:      false,
-					CustomerId: check.CustomerId,
-				},
-				Region: metaData.Region,
-				VpcId:  metaData.VpcId,
-				MaxAge: defaultResponseCacheTTL,
+				RetryPolicy:            check.RetryPolicy,
+				User:                   user,
+				Region:                 metaData.Region,
+				VpcId:                  metaData.VpcId,
+				MaxAge:                 defaultResponseCacheTTL,
+				Backend: NewAWSBackend(globalConfig.AWSBackend, &BezosBackend{
+					Client: BezosClient,
+					User:   user,
+					Region: metaData.Region,
+					VpcId:  metaData.VpcId,
+					MaxAge: defaultResponseCacheTTL * 2,
+				}, DirectAWS(globalConfig)),
 			}
 
 		default:
@@ -327,8 +584,13 @@ func (r *Runner) dispatch(ctx context.Context, check *schema.Check, targets []*s
 	return r.dispatcher.Dispatch(ctx, tg), nil
 }
 
-func (r *Runner) runAssertions(ctx context.Context, check *schema.Check, tasks chan *Task) []*schema.CheckResponse {
-	responses := []*schema.CheckResponse{}
+// errSlateUnavailable is returned when a check's assertions could not be run.
+var errSlateUnavailable = errors.New("Could not contact slate.")
+
+// runAssertions runs the check's assertions against each task as it finishes,
+// sending its CheckResponse to responses. It stops at the first task whose
+// assertions can't be run.
+func (r *Runner) runAssertions(ctx context.Context, check *schema.Check, tasks chan *Task, responses chan<- *schema.CheckResponse) error {
 	for t := range tasks {
 		if t.Response == nil {
 			log.WithFields(log.Fields{"task": *t}).Error("Task has no response")
@@ -337,59 +599,147 @@ func (r *Runner) runAssertions(ctx context.Context, check *schema.Check, tasks c
 
 		log.WithFields(log.Fields{"task": *t}).Debug("runAssertions - Handling finished task.")
 
-		passing := false
+		response, err := r.checkResponse(ctx, check, t)
+		if err != nil {
+			return err
+		}
+		responses <- response
+	}
+
+	return nil
+}
+
+// checkResponse returns the CheckResponse for a finished task.
+func (r *Runner) checkResponse(ctx context.Context, check *schema.Check, t *Task) (*schema.CheckResponse, error) {
+	// Targets only pass if their responses are asserted on.
+	passing, asserted := false, false
+
+	response := &schema.CheckResponse{
+		Target:        t.Target,
+		Reply:         t.Response.Response,
+		Attempts:      int32(t.Response.Attempts),
+		AttemptErrors: t.Response.AttemptErrors,
+		Diagnostics:   t.Response.Diagnostics,
+	}
+
+	if e := t.Response.Error; e != nil {
+		setResponseError(response, e)
+		_, response.TimedOut = e.(*TimeoutError)
+	}
 
-		response := &schema.CheckResponse{
-			Target: t.Target,
-			Reply:  t.Response.Response,
+	if response.Error == "" && len(check.Assertions) > 0 && r.slateClient != nil {
+		var (
+			jsonBytes json.RawMessage
+			err       error
+		)
+		switch t := response.Reply.(type) {
+		case *schema.CheckResponse_HttpResponse:
+			jsonBytes, err = json.Marshal(t.HttpResponse)
+		case *schema.CheckResponse_CloudwatchResponse:
+			jsonBytes, err = json.Marshal(t.CloudwatchResponse)
+		case *schema.CheckResponse_HttpTransactionResponse:
+			// Check-level assertions apply to the response of the final step.
+			steps := t.HttpTransactionResponse.Steps
+			jsonBytes, err = json.Marshal(steps[len(steps)-1].Response)
+		default:
+			err = fmt.Errorf("reply type not found: %#v", t)
 		}
 
-		if e := t.Response.Error; e != nil {
-			response.Error = e.Error()
+		if err != nil {
+			log.WithError(err).Error("Couldn't marshal check response reply.")
+			return nil, err
 		}
 
-		if response.Error == "" && len(check.Assertions) > 0 && r.slateClient != nil {
-			var (
-				jsonBytes json.RawMessage
-				err       error
-			)
-			switch t := response.Reply.(type) {
-			case *schema.CheckResponse_HttpResponse:
-				jsonBytes, err = json.Marshal(t.HttpResponse)
-			case *schema.CheckResponse_CloudwatchResponse:
-				jsonBytes, err = json.Marshal(t.CloudwatchResponse)
-			default:
-				err = fmt.Errorf("reply type not found: %#v", t)
-			}
+		passing, err = r.slateClient.CheckAssertions(ctx, check, jsonBytes)
+		if err != nil {
+			// even one failure with contacting slate will cause the check to not be run
+			log.WithError(err).Error("Could not contact slate.")
+			return nil, errSlateUnavailable
+		}
+		asserted = true
+	}
 
-			if err != nil {
-				log.WithError(err).Error("Couldn't marshal check response reply.")
-				return nil
-			}
+	if txn, ok := response.Reply.(*schema.CheckResponse_HttpTransactionResponse); ok && response.Error == "" && r.slateClient != nil {
+		stepsPassing, err := r.runStepAssertions(ctx, check, txn.HttpTransactionResponse)
+		if err != nil {
+			log.WithError(err).Error("Could not contact slate.")
+			return nil, errSlateUnavailable
+		}
+		if len(check.Assertions) == 0 {
+			passing = stepsPassing
+		} else {
+			passing = passing && stepsPassing
+		}
+		asserted = true
+	}
+	log.WithFields(log.Fields{"Check Name": check.Name, "Check Id": check.Id}).Debugf("Check is passing: %t", passing)
 
-			passing, err = r.slateClient.CheckAssertions(ctx, check, jsonBytes)
-			if err != nil {
-				// even one failure with contacting slate will cause the check to not be run
-				log.WithError(err).Error("Could not contact slate.")
-				return nil
-			}
+	response.Passing = passing
+	if asserted && !passing && response.Error == "" {
+		response.ErrorCategory = errs.Assertion
+	}
+	return response, nil
+}
+
+// runStepAssertions evaluates each transaction step's own assertions against
+// that step's response, recording the outcome on the step. It returns true if
+// every step passed.
+func (r *Runner) runStepAssertions(ctx context.Context, check *schema.Check, txn *schema.HttpTransactionResponse) (bool, error) {
+	txnCheck := check.GetHttpTransactionCheck()
+	if txnCheck == nil {
+		return false, nil
+	}
+
+	passing := true
+	for i, stepResponse := range txn.Steps {
+		if i >= len(txnCheck.Steps) || stepResponse.Response == nil {
+			passing = false
+			continue
 		}
-		log.WithFields(log.Fields{"Check Name": check.Name, "Check Id": check.Id}).Debugf("Check is passing: %t", passing)
 
-		response.Passing = passing
-		responses = append(responses, response)
+		step := txnCheck.Steps[i]
+		if len(step.Assertions) == 0 {
+			stepResponse.Passing = true
+			continue
+		}
+
+		jsonBytes, err := json.Marshal(stepResponse.Response)
+		if err != nil {
+			return false, err
+		}
+
+		stepResponse.Passing, err = r.slateClient.EvaluateAssertions(ctx, step.Assertions, jsonBytes)
+		if err != nil {
+			return false, err
+		}
+
+		if !stepResponse.Passing {
+			passing = false
+		}
 	}
 
-	return responses
+	return passing, nil
 }
 
-// If the Context passed to RunCheck includes a MaxHosts value, at most MaxHosts
-// CheckResponse objects will be returned.
-//
-// If the Context passed to RunCheck is cancelled or its deadline is exceeded,
-// all CheckResponse objects after that event will be passed to the channel
-// with appropriate errors associated with them.
-func (r *Runner) RunCheck(ctx context.Context, check *schema.Check, targets []*schema.Target) ([]*schema.CheckResponse, error) {
+// A CheckResponseStream delivers a check's responses as they're ready. See
+// Runner.StreamCheck.
+type CheckResponseStream struct {
+	// Responses receives a CheckResponse for each target as soon as its
+	// assertions have run, and is closed once every target has one.
+	Responses <-chan *schema.CheckResponse
+	err       error
+}
+
+// Err returns the reason the stream ended early, if it did. It must only be
+// called once Responses is closed.
+func (s *CheckResponseStream) Err() error {
+	return s.err
+}
+
+// StreamCheck runs a check like RunCheck, but returns as soon as the check is
+// dispatched, so that the responses for fast targets needn't wait on slow
+// ones.
+func (r *Runner) StreamCheck(ctx context.Context, check *schema.Check, targets []*schema.Target) (*CheckResponseStream, error) {
 	var (
 		maxHosts int
 		ok       bool
@@ -403,22 +753,55 @@ func (r *Runner) RunCheck(ctx context.Context, check *schema.Check, targets []*s
 	}
 	targets = targets[:maxHosts]
 
-	// tasks is a channel of tasks which runCheck will iterate over.
-	tasks, err := r.dispatch(ctx, check, targets)
+	// Requests are abandoned at the check's timeout, but assertions are still
+	// run on the responses once they have been.
+	timeout := checkTimeout(check)
+	runCtx, cancel := context.WithTimeout(ctx, timeout)
+
+	// tasks is a channel of tasks which runAssertions will iterate over.
+	tasks, err := r.dispatch(runCtx, check, targets)
 	if err != nil {
+		cancel()
 		return nil, err
 	}
 
-	if tasks == nil {
-		return nil, nil
-	}
+	responses := make(chan *schema.CheckResponse, len(targets))
+	stream := &CheckResponseStream{Responses: responses}
 
 	// TODO(greg): Move assertion processing to a parallel model, but for now
-	// try to be a little nicer to slate and run these serially, blocking until
-	// all assertions have been processed.
+	// try to be a little nicer to slate and run these serially.
+	go func() {
+		stream.err = r.runAssertions(ctx, check, markTimeouts(ctx, runCtx, timeout, tasks), responses)
+		cancel()
+		close(responses)
+	}()
+
+	return stream, nil
+}
+
+// If the Context passed to RunCheck includes a MaxHosts value, at most MaxHosts
+// CheckResponse objects will be returned.
+//
+// If the Context passed to RunCheck is cancelled or its deadline is exceeded,
+// all CheckResponse objects after that event will be passed to the channel
+// with appropriate errors associated with them.
+func (r *Runner) RunCheck(ctx context.Context, check *schema.Check, targets []*schema.Target) ([]*schema.CheckResponse, error) {
+	stream, err := r.StreamCheck(ctx, check, targets)
+	if err != nil {
+		return nil, err
+	}
+
+	responses := []*schema.CheckResponse{}
+	for response := range stream.Responses {
+		responses = append(responses, response)
+	}
+
 	// In the event of a slate failure, we're return nil for the list of responses,
 	// which will skip putting results on the queue!
 	// TODO: Alert opsee in that scenario
-	responses := r.runAssertions(ctx, check, tasks)
+	if stream.Err() != nil {
+		return nil, nil
+	}
+
 	return responses, nil
 }
@@ -164,6 +164,29 @@ func (s *RunnerTestSuite) TestRunCheckBadCheckReturnsError() {
 	assert.Nil(s.T(), responses)
 }
 
+func (s *RunnerTestSuite) TestRunCheckOtherTypeReturnsError() {
+	check := s.Common.PassingCheck()
+	targets, err := s.Resolver.Resolve(s.Context, check.Target)
+	if err != nil {
+		log.Fatal("Failed to get test targets")
+	}
+
+	runner := NewRunner(&schema.CloudWatchCheck{})
+	assert.False(s.T(), runner.Handles(check))
+
+	responses, err := runner.RunCheck(s.Context, check, targets)
+	assert.Error(s.T(), err)
+	assert.Nil(s.T(), responses)
+}
+
+func (s *RunnerTestSuite) TestRunnerCheckTypes() {
+	assert.Equal(s.T(), []string{HTTPCheckType}, NewRunner(&schema.HttpCheck{}).CheckTypes())
+	assert.Equal(s.T(), []string{CloudWatchCheckType}, NewRunner(&schema.CloudWatchCheck{}).CheckTypes())
+	assert.Equal(s.T(), CheckTypes, NewRunner(nil).CheckTypes())
+	assert.True(s.T(), NewRunner(nil).Handles(s.Common.PassingCheck()))
+	assert.Equal(s.T(), "runner_http", CheckTopic(RunnerTopic, CheckTypeName(s.Common.PassingCheck())))
+}
+
 func TestRunnerTestSuite(t *testing.T) {
 	setupTestEnv()
 	suite.Run(t, new(RunnerTestSuite))
@@ -202,11 +225,11 @@ func (s *NSQRunnerTestSuite) SetupSuite() {
 
 	s.ResetNsqConfig = resetNsqConfig{
 		Topics: []NsqTopic{
-			NsqTopic{s.Config.ConsumerQueueName},
+			NsqTopic{CheckTopic(s.Config.ConsumerQueueName, HTTPCheckType)},
 			NsqTopic{s.Config.ProducerQueueName},
 		},
 		Channels: []NsqChannel{
-			NsqChannel{s.Config.ConsumerQueueName, s.Config.ConsumerChannelName},
+			NsqChannel{CheckTopic(s.Config.ConsumerQueueName, HTTPCheckType), s.Config.ConsumerChannelName},
 			NsqChannel{s.Config.ProducerQueueName, "test-runner-results"},
 		},
 	}
@@ -259,7 +282,7 @@ func (s *NSQRunnerTestSuite) TestHandlerDoesItsThing() {
 	check := s.Common.PassingCheck()
 	checkWithTargets, _ := NewCheckTargets(s.Resolver, check)
 	msg, _ := proto.Marshal(checkWithTargets)
-	s.Producer.Publish(s.Config.ConsumerQueueName, msg)
+	s.Producer.Publish(CheckTopic(s.Config.ConsumerQueueName, HTTPCheckType), msg)
 	timer := time.NewTimer(10 * time.Second)
 	select {
 	case m := <-s.MsgChan:
@@ -278,13 +301,13 @@ func (s *NSQRunnerTestSuite) TestResultsHaveCorrectCustomerId() {
 	check1 := s.Common.PassingCheck()
 	cwt1, _ := NewCheckTargets(s.Resolver, check1)
 	msg1, _ := proto.Marshal(cwt1)
-	s.Producer.Publish(s.Config.ConsumerQueueName, msg1)
+	s.Producer.Publish(CheckTopic(s.Config.ConsumerQueueName, HTTPCheckType), msg1)
 
 	check2 := s.Common.PassingCheck()
 	check2.CustomerId = "check2-customer-id"
 	cwt2, _ := NewCheckTargets(s.Resolver, check2)
 	msg2, _ := proto.Marshal(cwt2)
-	s.Producer.Publish(s.Config.ConsumerQueueName, msg2)
+	s.Producer.Publish(CheckTopic(s.Config.ConsumerQueueName, HTTPCheckType), msg2)
 
 	timer := time.NewTimer(10 * time.Second)
 
@@ -8,6 +8,7 @@ import (
 	log "github.com/Sirupsen/logrus"
 	"github.com/gogo/protobuf/proto"
 	"github.com/opsee/basic/schema"
+	"github.com/opsee/bastion/errs"
 	opsee_types "github.com/opsee/protobuf/opseeproto/types"
 )
 
@@ -29,6 +30,37 @@ func validateCheck(check *schema.Check) error {
 	if check.Spec == nil {
 		return fmt.Errorf("Check has null Spec")
 	}
+	if check.Target.Type == "tag" {
+		if _, err := parseTagFilters(check.Target.Id); err != nil {
+			return err
+		}
+	}
+	if err := validateAggregationPolicy(check.AggregationPolicy); err != nil {
+		return err
+	}
+	if err := validateDependencies(check); err != nil {
+		return err
+	}
+	if httpCheck := check.GetHttpCheck(); httpCheck != nil {
+		if err := validateRedirectPolicy(httpCheck.RedirectPolicy); err != nil {
+			return err
+		}
+		if err := validateAuth(httpCheck.Auth); err != nil {
+			return err
+		}
+		if err := validateWebSocketScript(httpCheck.Websocket); err != nil {
+			return err
+		}
+	}
+	if err := validateCaptures(check.GetHttpTransactionCheck()); err != nil {
+		return err
+	}
+	if check.Timeout < 0 {
+		return fmt.Errorf("Check timeout is negative: %d", check.Timeout)
+	}
+	if check.RecheckInterval != 0 && (check.RecheckInterval < MinimumCheckInterval || check.RecheckInterval >= check.Interval) {
+		return fmt.Errorf("Check recheck interval must be at least %d and less than its interval: %d", MinimumCheckInterval, check.RecheckInterval)
+	}
 
 	return nil
 }
@@ -41,7 +73,15 @@ type CheckTimer struct {
 	Check   *schema.Check
 	runChan chan *schema.Check
 	stop    chan struct{}
-	ticker  *time.Ticker
+	reset   chan time.Duration
+
+	sync.Mutex
+	ticker   *time.Ticker
+	interval time.Duration
+	// failures is the number of results in a row that have failed.
+	failures int
+	// rechecking is true while the check runs at its recheck interval.
+	rechecking bool
 }
 
 // NewCheckTimer creates a new timer and associates the given channel with that timer.
@@ -55,19 +95,32 @@ func NewCheckTimer(check *schema.Check, runChan chan *schema.Check) (*CheckTimer
 		return nil, err
 	}
 	ct := &CheckTimer{
-		check,
-		runChan,
-		make(chan struct{}, 1),
-		time.NewTicker(d),
+		Check:    check,
+		runChan:  runChan,
+		stop:     make(chan struct{}, 1),
+		reset:    make(chan time.Duration, 1),
+		ticker:   time.NewTicker(d),
+		interval: d,
 	}
 
 	go func() {
 		for {
+			ct.Lock()
+			ticks := ct.ticker.C
+			ct.Unlock()
+
 			select {
-			case <-ct.ticker.C:
+			case <-ticks:
 				ct.runChan <- ct.Check
+			case d := <-ct.reset:
+				ct.Lock()
+				ct.ticker.Stop()
+				ct.ticker = time.NewTicker(d)
+				ct.Unlock()
 			case <-ct.stop:
+				ct.Lock()
 				ct.ticker.Stop()
+				ct.Unlock()
 				return
 			}
 		}
@@ -81,6 +134,59 @@ func (c *CheckTimer) Stop() {
 	c.stop <- struct{}{}
 }
 
+// setInterval changes the interval at which the check is sent, starting
+// from now. The caller must hold the lock.
+func (c *CheckTimer) setInterval(d time.Duration) {
+	// Only the latest interval matters.
+	select {
+	case <-c.reset:
+	default:
+	}
+	c.reset <- d
+}
+
+// RecordResult updates the timer with whether the check's latest result
+// passed. A check with a recheck interval is run at that interval once it
+// fails, until it passes or reaches its minimum failing count, and then
+// returns to its usual interval.
+func (c *CheckTimer) RecordResult(passing bool) {
+	c.Lock()
+	defer c.Unlock()
+
+	if passing {
+		c.failures = 0
+	} else {
+		c.failures++
+	}
+	c.reschedule()
+}
+
+// threshold is the number of failures in a row at which the check is
+// considered failing.
+func (c *CheckTimer) threshold() int {
+	if c.Check.MinFailingCount < 1 {
+		return 1
+	}
+	return int(c.Check.MinFailingCount)
+}
+
+// reschedule runs the check at its recheck interval or its usual one, given
+// its failures. The caller must hold the lock.
+func (c *CheckTimer) reschedule() {
+	recheck := c.Check.RecheckInterval > 0 && c.failures > 0 && c.failures < c.threshold()
+	if recheck == c.rechecking {
+		return
+	}
+	c.rechecking = recheck
+
+	d := c.interval
+	if recheck {
+		d = time.Duration(c.Check.RecheckInterval) * time.Second
+	}
+	log.WithFields(log.Fields{"check_id": c.Check.Id, "interval": d, "failures": c.failures}).Debug("Changing check interval.")
+	c.setInterval(d)
+}
+
 /*******************************************************************************
  * schedule map
  ******************************************************************************/
@@ -107,13 +213,33 @@ func (m *scheduleMap) RunChan() chan *schema.Check {
 
 func (m *scheduleMap) Set(key string, check *schema.Check) (*CheckTimer, error) {
 	m.Lock()
-	defer m.Unlock()
+	if cycle := m.dependencyCycle(check); cycle != nil {
+		m.Unlock()
+		return nil, dependencyCycleError(cycle)
+	}
 	ct, err := NewCheckTimer(check, m.runChan)
 	if err != nil {
+		m.Unlock()
 		return nil, err
 	}
+	// A check that's redefined keeps its failures, so that updating it doesn't
+	// reset how long it's been failing.
+	if old, ok := m.checks[key]; ok {
+		old.Stop()
+		old.Lock()
+		failures := old.failures
+		old.Unlock()
+
+		ct.Lock()
+		ct.failures = failures
+		ct.reschedule()
+		ct.Unlock()
+	}
 	m.checks[key] = ct
+	m.Unlock()
 
+	// The scheduler needs the lock to consume from runChan, so don't hold it
+	// while sending.
 	m.runChan <- ct.Check
 
 	return ct, nil
@@ -151,7 +277,9 @@ func (m *scheduleMap) Destroy() {
 	m.Lock()
 	defer m.Unlock()
 	for _, check := range m.checks {
+		check.Lock()
 		check.ticker.Stop()
+		check.Unlock()
 	}
 	close(m.runChan)
 }
@@ -203,6 +331,8 @@ func (s *Scheduler) CreateCheck(check *schema.Check) (*schema.Check, error) {
 			check.Spec = &schema.Check_HttpCheck{spec}
 		case *schema.CloudWatchCheck:
 			check.Spec = &schema.Check_CloudwatchCheck{spec}
+		case *schema.HttpTransactionCheck:
+			check.Spec = &schema.Check_HttpTransactionCheck{HttpTransactionCheck: spec}
 		}
 	}
 
@@ -218,6 +348,14 @@ func (s *Scheduler) CreateCheck(check *schema.Check) (*schema.Check, error) {
 	return ct.Check, nil
 }
 
+// UpdateCheck replaces a check, keeping the history of its results. A check
+// that isn't scheduled is created. If the new definition is rejected, the
+// check is left as it was.
+
+func (s *Scheduler) UpdateCheck(check *schema.Check) (*schema.Check, error) {
+	return s.CreateCheck(check)
+}
+
 // Retrieve a Check by ID. If a check associated with the ID exists, then it
 // will be returned. Otherwise, it will return nil and an error indicating the
 // check does not exist.
@@ -254,6 +392,14 @@ func (s *Scheduler) DeleteCheck(check *schema.Check) (*schema.Check, error) {
 	return c.Check, err
 }
 
+// HandleResult lets the scheduler adjust a check's schedule given its latest
+// result. Results for checks that aren't scheduled are ignored.
+func (s *Scheduler) HandleResult(result *schema.CheckResult) {
+	if ct := s.scheduleMap.Get(result.CheckId); ct != nil {
+		ct.RecordResult(result.Passing)
+	}
+}
+
 // Start scheduling checks from this Scheduler's ScheduleMap
 
 func (s *Scheduler) Start() error {
@@ -274,6 +420,12 @@ func (s *Scheduler) Start() error {
 					err              error
 				)
 
+				failingParents := s.scheduleMap.FailingParents(check)
+				if len(failingParents) > 0 && check.DependencyMode == DependencySkip {
+					log.WithFields(log.Fields{"check_id": check.Id, "failing_parents": failingParents}).Debug("Skipping check with failing dependencies.")
+					continue
+				}
+
 				// TODO(greg): Clean this up and get rid of schema.CheckTargets.
 				checkWithTargets, err = NewCheckTargets(s.resolver, check)
 				if err != nil {
@@ -286,6 +438,11 @@ func (s *Scheduler) Start() error {
 						Targets: nil,
 					}
 				}
+				if err != nil {
+					checkWithTargets.Error = err.Error()
+					checkWithTargets.ErrorCategory = errs.Classify(err)
+				}
+				checkWithTargets.FailingParents = failingParents
 
 				msg, err := proto.Marshal(checkWithTargets)
 				if err != nil {
@@ -295,7 +452,10 @@ func (s *Scheduler) Start() error {
 					// be centralized and easily managed. It can just be a static file or
 					// something that every microservice refers to--just to make sure
 					// they're all on the same page.
-					if err := s.Producer.Publish("runner", msg); err != nil {
+					checkType := CheckTypeName(check)
+					if checkType == "" {
+						log.WithFields(log.Fields{"check_id": check.Id}).Error("Not scheduling check of unknown type.")
+					} else if err := s.Producer.Publish(CheckTopic(RunnerTopic, checkType), msg); err != nil {
 						log.Error(err.Error())
 					} else {
 						log.Debug("Scheduled check for execution: %s", check.Id)
@@ -56,6 +56,62 @@ func (s *SchedulerTestSuite) TestCheckWithoutSpecIsInvalid() {
 	assert.Error(s.T(), validateCheck(check))
 }
 
+func (s *SchedulerTestSuite) TestCheckWithBadRecheckIntervalIsInvalid() {
+	check := s.Common.Check()
+	check.Interval = 60
+	check.RecheckInterval = 60
+	assert.Error(s.T(), validateCheck(check))
+	check.RecheckInterval = 1
+	assert.Error(s.T(), validateCheck(check))
+	check.RecheckInterval = 15
+	assert.NoError(s.T(), validateCheck(check))
+}
+
+func (s *SchedulerTestSuite) TestCheckWithBadTagTargetIsInvalid() {
+	check := s.Common.Check()
+	check.Target = &schema.Target{Type: "tag", Id: "service"}
+	assert.Error(s.T(), validateCheck(check))
+	check.Target.Id = "service=payments,env=prod"
+	assert.NoError(s.T(), validateCheck(check))
+}
+
+func (s *SchedulerTestSuite) TestCheckWithUnknownRedirectPolicyIsInvalid() {
+	check := s.Common.Check()
+	check.GetHttpCheck().RedirectPolicy = "sometimes"
+	assert.Error(s.T(), validateCheck(check))
+	check.GetHttpCheck().RedirectPolicy = "same_host"
+	assert.NoError(s.T(), validateCheck(check))
+}
+
+func (s *SchedulerTestSuite) TestCheckWithInvalidAuthIsInvalid() {
+	check := s.Common.Check()
+	check.GetHttpCheck().Auth = &schema.HttpAuth{Type: "digest"}
+	assert.Error(s.T(), validateCheck(check))
+	check.GetHttpCheck().Auth = &schema.HttpAuth{Type: "bearer"}
+	assert.Error(s.T(), validateCheck(check))
+	check.GetHttpCheck().Auth.Token = "token"
+	assert.NoError(s.T(), validateCheck(check))
+}
+
+func (s *SchedulerTestSuite) TestCheckWithUnknownStepsIsInvalid() {
+	check := s.Common.Check()
+	check.GetHttpCheck().Websocket = &schema.WebSocketScript{
+		Steps: []*schema.WebSocketStep{{Action: "receive"}},
+	}
+	assert.Error(s.T(), validateCheck(check))
+	check.GetHttpCheck().Websocket.Steps[0].Action = "expect"
+	assert.NoError(s.T(), validateCheck(check))
+
+	check.Spec = &schema.Check_HttpTransactionCheck{HttpTransactionCheck: &schema.HttpTransactionCheck{
+		Steps: []*schema.HttpTransactionStep{
+			{Captures: []*schema.Capture{{Name: "token", Source: "cookie"}}},
+		},
+	}}
+	assert.Error(s.T(), validateCheck(check))
+	check.GetHttpTransactionCheck().Steps[0].Captures[0].Source = "header"
+	assert.NoError(s.T(), validateCheck(check))
+}
+
 /*******************************************************************************
  * CreateCheck()
  ******************************************************************************/
@@ -124,6 +180,184 @@ func (s *SchedulerTestSuite) TestDeleteReturnsOriginalCheck() {
 	assert.Equal(s.T(), check.Id, c.Id, "DeleteCheck returned incorrect check ID.")
 }
 
+/*******************************************************************************
+ * CheckTimer
+ ******************************************************************************/
+
+func (s *SchedulerTestSuite) TestCheckTimerRechecksUntilFailingCount() {
+	check := s.Common.Check()
+	check.Interval = 60
+	check.RecheckInterval = 15
+	check.MinFailingCount = 3
+
+	ct, err := NewCheckTimer(check, make(chan *schema.Check, 1))
+	assert.NoError(s.T(), err)
+	defer ct.Stop()
+
+	rechecking := func() bool {
+		ct.Lock()
+		defer ct.Unlock()
+		return ct.rechecking
+	}
+
+	ct.RecordResult(false)
+	assert.True(s.T(), rechecking())
+	ct.RecordResult(false)
+	assert.True(s.T(), rechecking())
+
+	// At the failing count, the check returns to its interval.
+	ct.RecordResult(false)
+	assert.False(s.T(), rechecking())
+	ct.RecordResult(false)
+	assert.False(s.T(), rechecking())
+
+	ct.RecordResult(true)
+	assert.False(s.T(), rechecking())
+	ct.RecordResult(false)
+	assert.True(s.T(), rechecking())
+	ct.RecordResult(true)
+	assert.False(s.T(), rechecking())
+}
+
+func (s *SchedulerTestSuite) TestCheckTimerWithoutRecheckInterval() {
+	check := s.Common.Check()
+	check.MinFailingCount = 3
+
+	ct, err := NewCheckTimer(check, make(chan *schema.Check, 1))
+	assert.NoError(s.T(), err)
+	defer ct.Stop()
+
+	ct.RecordResult(false)
+	ct.Lock()
+	assert.False(s.T(), ct.rechecking)
+	ct.Unlock()
+}
+
+/*******************************************************************************
+ * Dependencies
+ ******************************************************************************/
+
+func (s *SchedulerTestSuite) TestCheckWithBadDependenciesIsInvalid() {
+	check := s.Common.Check()
+	check.DependsOn = []string{check.Id}
+	assert.Error(s.T(), validateCheck(check))
+
+	check.DependsOn = []string{"parent"}
+	check.DependencyMode = "ignore"
+	assert.Error(s.T(), validateCheck(check))
+	check.DependencyMode = DependencySkip
+	assert.NoError(s.T(), validateCheck(check))
+}
+
+func (s *SchedulerTestSuite) TestCreateCheckRejectsDependencyCycle() {
+	scheduler := s.Scheduler
+
+	a := s.Common.Check()
+	a.Id = "a"
+	a.DependsOn = []string{"c"}
+	_, err := scheduler.CreateCheck(a)
+	assert.NoError(s.T(), err)
+
+	b := s.Common.Check()
+	b.Id = "b"
+	b.DependsOn = []string{"a"}
+	_, err = scheduler.CreateCheck(b)
+	assert.NoError(s.T(), err)
+
+	c := s.Common.Check()
+	c.Id = "c"
+	c.DependsOn = []string{"b"}
+	_, err = scheduler.CreateCheck(c)
+	assert.Error(s.T(), err)
+	assert.Nil(s.T(), scheduler.scheduleMap.Get("c"))
+
+	// Updating a check is subject to the same rule.
+	c.DependsOn = nil
+	_, err = scheduler.CreateCheck(c)
+	assert.NoError(s.T(), err)
+	c.DependsOn = []string{"b"}
+	_, err = scheduler.CreateCheck(c)
+	assert.Error(s.T(), err)
+}
+
+func (s *SchedulerTestSuite) TestFailingParents() {
+	scheduler := s.Scheduler
+
+	parent := s.Common.Check()
+	parent.Id = "parent"
+	_, err := scheduler.CreateCheck(parent)
+	assert.NoError(s.T(), err)
+
+	child := s.Common.Check()
+	child.Id = "child"
+	child.DependsOn = []string{"parent", "unknown"}
+	_, err = scheduler.CreateCheck(child)
+	assert.NoError(s.T(), err)
+
+	assert.Empty(s.T(), scheduler.scheduleMap.FailingParents(child))
+
+	scheduler.HandleResult(&schema.CheckResult{CheckId: "parent", Passing: false})
+	assert.Equal(s.T(), []string{"parent"}, scheduler.scheduleMap.FailingParents(child))
+
+	scheduler.HandleResult(&schema.CheckResult{CheckId: "parent", Passing: true})
+	assert.Empty(s.T(), scheduler.scheduleMap.FailingParents(child))
+}
+
+func (s *SchedulerTestSuite) TestFailingParentsUsesParentsFailingCount() {
+	scheduler := s.Scheduler
+
+	parent := s.Common.Check()
+	parent.Id = "parent"
+	parent.MinFailingCount = 2
+	_, err := scheduler.CreateCheck(parent)
+	assert.NoError(s.T(), err)
+
+	child := s.Common.Check()
+	child.Id = "child"
+	child.DependsOn = []string{"parent"}
+	_, err = scheduler.CreateCheck(child)
+	assert.NoError(s.T(), err)
+
+	scheduler.HandleResult(&schema.CheckResult{CheckId: "parent", Passing: false})
+	assert.Empty(s.T(), scheduler.scheduleMap.FailingParents(child))
+	scheduler.HandleResult(&schema.CheckResult{CheckId: "parent", Passing: false})
+	assert.Equal(s.T(), []string{"parent"}, scheduler.scheduleMap.FailingParents(child))
+}
+
+func (s *SchedulerTestSuite) TestUpdateCheckKeepsFailures() {
+	scheduler := s.Scheduler
+
+	parent := s.Common.Check()
+	parent.Id = "parent"
+	_, err := scheduler.CreateCheck(parent)
+	assert.NoError(s.T(), err)
+
+	child := s.Common.Check()
+	child.Id = "child"
+	child.DependsOn = []string{"parent"}
+	_, err = scheduler.CreateCheck(child)
+	assert.NoError(s.T(), err)
+
+	scheduler.HandleResult(&schema.CheckResult{CheckId: "parent", Passing: false})
+
+	updated := s.Common.Check()
+	updated.Id = "parent"
+	updated.Interval = 30
+	_, err = scheduler.UpdateCheck(updated)
+	assert.NoError(s.T(), err)
+	assert.EqualValues(s.T(), 30, scheduler.scheduleMap.Get("parent").Check.Interval)
+	assert.Equal(s.T(), []string{"parent"}, scheduler.scheduleMap.FailingParents(child))
+
+	// A rejected update leaves the check as it was.
+	cyclic := s.Common.Check()
+	cyclic.Id = "parent"
+	cyclic.DependsOn = []string{"child"}
+	_, err = scheduler.UpdateCheck(cyclic)
+	assert.Error(s.T(), err)
+	assert.EqualValues(s.T(), 30, scheduler.scheduleMap.Get("parent").Check.Interval)
+	assert.Empty(s.T(), scheduler.scheduleMap.Get("parent").Check.DependsOn)
+}
+
 /*******************************************************************************
  * RunCheck() Benchmarks
   ******************************************************************************/
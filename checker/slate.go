@@ -48,6 +48,12 @@ func NewSlateClient(slateUrl string) *SlateClient {
 // CheckAssertions issues a request to Slate to determine if a check response
 // is passing or failing.
 func (s *SlateClient) CheckAssertions(ctx context.Context, check *schema.Check, checkResponse json.RawMessage) (bool, error) {
+	return s.EvaluateAssertions(ctx, check.Assertions, checkResponse)
+}
+
+// EvaluateAssertions issues a request to Slate to determine if a response
+// satisfies an arbitrary set of assertions.
+func (s *SlateClient) EvaluateAssertions(ctx context.Context, assertions []*schema.Assertion, checkResponse json.RawMessage) (bool, error) {
 	var (
 		body        []byte
 		success     bool
@@ -56,7 +62,7 @@ func (s *SlateClient) CheckAssertions(ctx context.Context, check *schema.Check,
 	)
 
 	sr := &SlateRequest{
-		Assertions: check.Assertions,
+		Assertions: assertions,
 		Response:   checkResponse,
 	}
 
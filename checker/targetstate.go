@@ -0,0 +1,255 @@
+package checker
+
+import (
+	"sync"
+	"time"
+
+	"github.com/opsee/basic/schema"
+	opsee_types "github.com/opsee/protobuf/opseeproto/types"
+)
+
+const (
+	TargetStatePassing = "passing"
+	TargetStateFailing = "failing"
+
+	// TargetHistoryLength is the default number of each target's most recent
+	// outcomes that are remembered.
+	TargetHistoryLength = 21
+
+	// targetHistoryTTL is how long the history of a check that hasn't run is
+	// kept.
+	targetHistoryTTL = time.Hour
+)
+
+// FlapConfig configures flap detection. As with Nagios, a check or target is
+// flapping once the weighted percentage of its recent outcomes that changed
+// state reaches HighThreshold, and until it drops below LowThreshold. Nothing
+// is flapping until at least half of its window has been seen.
+type FlapConfig struct {
+	// Window is the number of recent outcomes considered.
+	Window int
+	// HighThreshold and LowThreshold are percentages, from 0 to 100.
+	HighThreshold float64
+	LowThreshold  float64
+}
+
+// DefaultFlapConfig is read when a runner is created.
+var DefaultFlapConfig = FlapConfig{
+	Window:        TargetHistoryLength,
+	HighThreshold: 50,
+	LowThreshold:  25,
+}
+
+// stateHistory is a sliding window of the outcomes of a check, or of one of
+// its targets.
+type stateHistory struct {
+	// outcomes are in order, the most recent last.
+	outcomes            []bool
+	state               string
+	lastChange          time.Time
+	consecutiveFailures int
+
+	flapping           bool
+	percentStateChange float64
+	// reported is the state last published in a transition, or the first
+	// state seen.
+	reported string
+}
+
+func (h *stateHistory) add(passing bool, at time.Time, flap FlapConfig) {
+	h.outcomes = append(h.outcomes, passing)
+	if len(h.outcomes) > flap.Window {
+		h.outcomes = h.outcomes[len(h.outcomes)-flap.Window:]
+	}
+
+	if passing {
+		h.consecutiveFailures = 0
+	} else {
+		h.consecutiveFailures++
+	}
+
+	state := stateName(passing)
+	if h.state != state {
+		h.lastChange = at
+	}
+	if h.state == "" {
+		h.reported = state
+	}
+	h.state = state
+
+	// Too short a history would make a single change look like flapping.
+	h.percentStateChange = percentStateChange(h.outcomes)
+	if len(h.outcomes) < flap.Window/2 {
+		h.flapping = false
+	} else if h.flapping {
+		h.flapping = h.percentStateChange >= flap.LowThreshold
+	} else {
+		h.flapping = h.percentStateChange >= flap.HighThreshold
+	}
+}
+
+// transition returns the state last reported, and true, if the state has
+// changed since then and the change should be reported now. Changes aren't
+// reported while flapping, so a target that stops flapping in a different
+// state to the one last reported transitions then.
+func (h *stateHistory) transition() (string, bool) {
+	if h.flapping || h.state == h.reported {
+		return "", false
+	}
+	from := h.reported
+	h.reported = h.state
+	return from, true
+}
+
+// percentStateChange returns the weighted percentage of outcomes that differ
+// from the one before. More recent changes are weighted more heavily, from
+// 0.8 for the oldest to 1.2 for the newest.
+func percentStateChange(outcomes []bool) float64 {
+	n := len(outcomes)
+	if n < 2 {
+		return 0
+	}
+
+	total := 0.0
+	for i := 1; i < n; i++ {
+		if outcomes[i] == outcomes[i-1] {
+			continue
+		}
+		weight := 1.0
+		if n > 2 {
+			weight = 0.8 + 0.4*float64(i-1)/float64(n-2)
+		}
+		total += weight
+	}
+
+	return total / float64(n-1) * 100
+}
+
+// checkHistory holds the history of a check, and of each of its targets by
+// target ID.
+type checkHistory struct {
+	*stateHistory
+	targets map[string]*stateHistory
+	lastRun time.Time
+}
+
+// A targetTracker remembers how each check and its targets have fared over
+// their recent runs.
+type targetTracker struct {
+	sync.Mutex
+	flap      FlapConfig
+	checks    map[string]*checkHistory
+	lastPrune time.Time
+}
+
+func newTargetTracker(flap FlapConfig) *targetTracker {
+	if flap.Window < 2 {
+		flap.Window = TargetHistoryLength
+	}
+
+	return &targetTracker{
+		flap:      flap,
+		checks:    make(map[string]*checkHistory),
+		lastPrune: time.Now(),
+	}
+}
+
+func targetKey(target *schema.Target) string {
+	if target.Id != "" {
+		return target.Id
+	}
+	return target.Address
+}
+
+func stateName(passing bool) string {
+	if passing {
+		return TargetStatePassing
+	}
+	return TargetStateFailing
+}
+
+// record adds the outcome of a check run to the history of the check and each
+// of its targets, annotating the result and each response with what's known
+// of their history. It returns a transition for every target whose state
+// changed, except those that are flapping. Targets absent from the run are
+// forgotten.
+func (t *targetTracker) record(result *schema.CheckResult, at time.Time) []*schema.TargetStateTransition {
+	t.Lock()
+	defer t.Unlock()
+
+	t.prune(at)
+
+	previous := t.checks[result.CheckId]
+	current := &checkHistory{
+		stateHistory: &stateHistory{},
+		targets:      make(map[string]*stateHistory),
+		lastRun:      at,
+	}
+	if previous != nil {
+		current.stateHistory = previous.stateHistory
+	}
+	t.checks[result.CheckId] = current
+
+	current.add(result.Passing, at, t.flap)
+	result.Flapping = current.flapping
+	result.PercentStateChange = current.percentStateChange
+
+	occurredAt := &opsee_types.Timestamp{}
+	occurredAt.Scan(at)
+
+	transitions := []*schema.TargetStateTransition{}
+	for _, response := range result.Responses {
+		if response.Target == nil {
+			continue
+		}
+		key := targetKey(response.Target)
+
+		h, ok := current.targets[key]
+		if !ok && previous != nil {
+			h, ok = previous.targets[key]
+		}
+		if !ok {
+			h = &stateHistory{}
+		}
+		current.targets[key] = h
+
+		h.add(response.Passing, at, t.flap)
+
+		if from, ok := h.transition(); ok {
+			transitions = append(transitions, &schema.TargetStateTransition{
+				CheckId:             result.CheckId,
+				CustomerId:          result.CustomerId,
+				BastionId:           result.BastionId,
+				Target:              response.Target,
+				From:                from,
+				To:                  h.state,
+				OccurredAt:          occurredAt,
+				ConsecutiveFailures: int32(h.consecutiveFailures),
+			})
+		}
+
+		lastChange := &opsee_types.Timestamp{}
+		lastChange.Scan(h.lastChange)
+		response.ConsecutiveFailures = int32(h.consecutiveFailures)
+		response.LastStateChange = lastChange
+		response.Flapping = h.flapping
+		response.PercentStateChange = h.percentStateChange
+	}
+
+	return transitions
+}
+
+// prune forgets the history of checks that haven't run for a while, e.g.
+// because they've been deleted. The caller must hold the lock.
+func (t *targetTracker) prune(now time.Time) {
+	if now.Sub(t.lastPrune) < targetHistoryTTL {
+		return
+	}
+	t.lastPrune = now
+
+	for id, c := range t.checks {
+		if now.Sub(c.lastRun) > targetHistoryTTL {
+			delete(t.checks, id)
+		}
+	}
+}
@@ -0,0 +1,137 @@
+package checker
+
+import (
+	"testing"
+	"time"
+
+	"github.com/opsee/basic/schema"
+	"github.com/stretchr/testify/assert"
+)
+
+func targetStateTestResult(passing ...bool) *schema.CheckResult {
+	ids := []string{"i-a", "i-b", "i-c"}
+	result := &schema.CheckResult{CheckId: "check-id"}
+	for i, p := range passing {
+		result.Responses = append(result.Responses, &schema.CheckResponse{
+			Target:  &schema.Target{Id: ids[i], Type: "instance"},
+			Passing: p,
+		})
+	}
+	return result
+}
+
+func TestTargetTrackerConsecutiveFailures(t *testing.T) {
+	tracker := newTargetTracker(DefaultFlapConfig)
+	start := time.Now()
+
+	transitions := tracker.record(targetStateTestResult(true, true), start)
+	assert.Len(t, transitions, 0)
+
+	var result *schema.CheckResult
+	for i := 1; i <= 3; i++ {
+		result = targetStateTestResult(true, false)
+		transitions = tracker.record(result, start.Add(time.Duration(i)*time.Minute))
+		if i == 1 {
+			assert.Len(t, transitions, 1)
+			assert.Equal(t, "i-b", transitions[0].Target.Id)
+			assert.Equal(t, TargetStatePassing, transitions[0].From)
+			assert.Equal(t, TargetStateFailing, transitions[0].To)
+		} else {
+			assert.Len(t, transitions, 0)
+		}
+	}
+
+	assert.EqualValues(t, 0, result.Responses[0].ConsecutiveFailures)
+	assert.EqualValues(t, 3, result.Responses[1].ConsecutiveFailures)
+
+	// The failing target changed state at its first failure, its sibling when
+	// first checked.
+	assert.Equal(t, start.Add(time.Minute).Unix(), result.Responses[1].LastStateChange.Seconds)
+	assert.Equal(t, start.Unix(), result.Responses[0].LastStateChange.Seconds)
+
+	result = targetStateTestResult(true, true)
+	transitions = tracker.record(result, start.Add(4*time.Minute))
+	assert.Len(t, transitions, 1)
+	assert.Equal(t, TargetStatePassing, transitions[0].To)
+	assert.EqualValues(t, 0, result.Responses[1].ConsecutiveFailures)
+}
+
+func TestTargetTrackerForgetsMissingTargets(t *testing.T) {
+	tracker := newTargetTracker(DefaultFlapConfig)
+	start := time.Now()
+
+	tracker.record(targetStateTestResult(true, false), start)
+	tracker.record(targetStateTestResult(true), start.Add(time.Minute))
+
+	// i-b is new again, so it can't have transitioned.
+	result := targetStateTestResult(true, true)
+	transitions := tracker.record(result, start.Add(2*time.Minute))
+	assert.Len(t, transitions, 0)
+	assert.Equal(t, start.Add(2*time.Minute).Unix(), result.Responses[1].LastStateChange.Seconds)
+}
+
+func TestStateHistoryWindow(t *testing.T) {
+	h := &stateHistory{}
+	for i := 0; i < TargetHistoryLength+5; i++ {
+		h.add(i%2 == 0, time.Now(), DefaultFlapConfig)
+	}
+	assert.Len(t, h.outcomes, TargetHistoryLength)
+	assert.Equal(t, (TargetHistoryLength+4)%2 == 0, h.outcomes[TargetHistoryLength-1])
+}
+
+func TestPercentStateChange(t *testing.T) {
+	assert.Equal(t, 0.0, percentStateChange([]bool{true}))
+	assert.Equal(t, 0.0, percentStateChange([]bool{true, true, true}))
+	assert.InDelta(t, 100.0, percentStateChange([]bool{true, false, true, false}), 1e-9)
+
+	// Recent changes count for more than old ones.
+	old := percentStateChange([]bool{true, false, false, false, false})
+	recent := percentStateChange([]bool{true, true, true, true, false})
+	assert.True(t, recent > old)
+}
+
+func TestTargetTrackerFlapping(t *testing.T) {
+	flap := FlapConfig{Window: 10, HighThreshold: 50, LowThreshold: 25}
+	tracker := newTargetTracker(flap)
+	at := time.Now()
+
+	run := func(passing bool) (*schema.CheckResult, []*schema.TargetStateTransition) {
+		at = at.Add(time.Minute)
+		result := targetStateTestResult(true, passing)
+		result.Passing = passing
+		return result, tracker.record(result, at)
+	}
+
+	// The target alternates, reporting its transitions until it's seen as
+	// flapping.
+	reported := 0
+	var result *schema.CheckResult
+	for i := 0; i < 10; i++ {
+		var transitions []*schema.TargetStateTransition
+		result, transitions = run(i%2 == 0)
+		reported += len(transitions)
+	}
+	assert.True(t, result.Responses[1].Flapping)
+	assert.False(t, result.Responses[0].Flapping)
+	assert.True(t, result.Flapping)
+	assert.Equal(t, 3, reported)
+
+	// Once flapping, transitions are suppressed.
+	_, transitions := run(true)
+	assert.Len(t, transitions, 0)
+	_, transitions = run(false)
+	assert.Len(t, transitions, 0)
+
+	// It stays flapping until the rate of change falls below the low
+	// threshold, then reports the change since the last transition, which
+	// was to failing.
+	reported = 0
+	for i := 0; i < 10 && result.Responses[1].Flapping; i++ {
+		result, transitions = run(true)
+		reported += len(transitions)
+	}
+	assert.False(t, result.Responses[1].Flapping)
+	assert.True(t, result.Responses[1].PercentStateChange < flap.LowThreshold)
+	assert.True(t, result.Responses[1].PercentStateChange > 0)
+	assert.Equal(t, 1, reported)
+}
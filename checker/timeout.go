@@ -0,0 +1,81 @@
+package checker
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/opsee/basic/schema"
+	"github.com/opsee/bastion/errs"
+	"golang.org/x/net/context"
+)
+
+// DefaultCheckTimeout is the longest a run of a check without a timeout may
+// take. Checks with a shorter interval time out after their interval.
+const DefaultCheckTimeout = time.Minute
+
+// checkTimeout returns how long a run of the check may take.
+func checkTimeout(check *schema.Check) time.Duration {
+	if check.Timeout > 0 {
+		return time.Duration(check.Timeout) * time.Second
+	}
+
+	timeout := time.Duration(check.Interval) * time.Second
+	if timeout <= 0 || timeout > DefaultCheckTimeout {
+		timeout = DefaultCheckTimeout
+	}
+	return timeout
+}
+
+// A TimeoutError is the response to a request that didn't finish within its
+// check's timeout.
+type TimeoutError struct {
+	Timeout time.Duration
+	// Err is the error the request failed with when it was abandoned.
+	Err error
+}
+
+func (e *TimeoutError) Error() string {
+	return fmt.Sprintf("check timed out after %s: %s", e.Timeout, e.Err)
+}
+
+// ErrorCategory implements errs.Categorized.
+func (e *TimeoutError) ErrorCategory() string {
+	return errs.Timeout
+}
+
+// withinDeadline returns d, or the time left until the context's deadline if
+// that's sooner, for the timeouts of operations that can't take a context.
+func withinDeadline(ctx context.Context, d time.Duration) time.Duration {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		return d
+	}
+
+	// A zero timeout usually means none at all.
+	if left := deadline.Sub(time.Now()); left < d {
+		d = left
+	}
+	if d <= 0 {
+		d = time.Nanosecond
+	}
+	return d
+}
+
+// markTimeouts passes on tasks, replacing the error of any that failed once
+// the context's deadline had passed with a TimeoutError. Tasks that failed
+// because the parent context was done are left as they are.
+func markTimeouts(parent, ctx context.Context, timeout time.Duration, tasks chan *Task) chan *Task {
+	marked := make(chan *Task, cap(tasks))
+
+	go func() {
+		defer close(marked)
+		for t := range tasks {
+			if t.Response != nil && t.Response.Error != nil && ctx.Err() == context.DeadlineExceeded && parent.Err() == nil {
+				t.Response.Error = &TimeoutError{Timeout: timeout, Err: t.Response.Error}
+			}
+			marked <- t
+		}
+	}()
+
+	return marked
+}
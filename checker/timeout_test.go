@@ -0,0 +1,104 @@
+package checker
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/opsee/basic/schema"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/context"
+)
+
+func TestCheckTimeout(t *testing.T) {
+	check := &schema.Check{Interval: 30}
+	assert.Equal(t, 30*time.Second, checkTimeout(check))
+
+	check.Interval = 300
+	assert.Equal(t, DefaultCheckTimeout, checkTimeout(check))
+
+	check.Timeout = 120
+	assert.Equal(t, 120*time.Second, checkTimeout(check))
+}
+
+func TestWithinDeadline(t *testing.T) {
+	assert.Equal(t, time.Minute, withinDeadline(context.Background(), time.Minute))
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	assert.True(t, withinDeadline(ctx, time.Minute) <= time.Second)
+	assert.Equal(t, time.Millisecond, withinDeadline(ctx, time.Millisecond))
+
+	ctx, cancel = context.WithDeadline(context.Background(), time.Unix(0, 0))
+	defer cancel()
+	assert.True(t, withinDeadline(ctx, time.Minute) > 0)
+}
+
+func TestHTTPRequestAdheresToDeadline(t *testing.T) {
+	unblock := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-unblock
+	}))
+	defer ts.Close()
+	defer close(unblock)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	request := &HTTPRequest{Method: "GET", URL: ts.URL}
+	t0 := time.Now()
+	response := <-request.Do(ctx)
+	assert.Error(t, response.Error)
+	assert.True(t, time.Since(t0) < 5*time.Second)
+}
+
+func TestHTTPRequestReadsBodyUntilDeadline(t *testing.T) {
+	unblock := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("partial"))
+		w.(http.Flusher).Flush()
+		<-unblock
+	}))
+	defer ts.Close()
+	defer close(unblock)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	request := &HTTPRequest{Method: "GET", URL: ts.URL}
+	response := <-request.Do(ctx)
+	assert.Equal(t, context.DeadlineExceeded, response.Error)
+	httpResponse := response.Response.(*schema.CheckResponse_HttpResponse).HttpResponse
+	assert.Equal(t, "partial", httpResponse.Body)
+	assert.Equal(t, truncatedTimeout, httpResponse.TruncatedReason)
+}
+
+func TestMarkTimeouts(t *testing.T) {
+	parent, cancelParent := context.WithCancel(context.Background())
+	ctx, cancel := context.WithDeadline(parent, time.Unix(0, 0))
+	defer cancel()
+
+	tasks := make(chan *Task, 3)
+	tasks <- &Task{Response: &Response{Error: errors.New("canceled")}}
+	tasks <- &Task{Response: &Response{}}
+	close(tasks)
+
+	marked := []*Task{}
+	for task := range markTimeouts(parent, ctx, time.Second, tasks) {
+		marked = append(marked, task)
+	}
+	assert.Len(t, marked, 2)
+	assert.IsType(t, &TimeoutError{}, marked[0].Response.Error)
+	assert.NoError(t, marked[1].Response.Error)
+
+	// The check's timeout isn't to blame once its parent is done.
+	cancelParent()
+	tasks = make(chan *Task, 1)
+	tasks <- &Task{Response: &Response{Error: errors.New("canceled")}}
+	close(tasks)
+	task := <-markTimeouts(parent, ctx, time.Second, tasks)
+	_, ok := task.Response.Error.(*TimeoutError)
+	assert.False(t, ok)
+}
@@ -0,0 +1,212 @@
+package checker
+
+import (
+	"crypto/tls"
+	"net"
+	"net/http/httptrace"
+	"sync"
+	"time"
+
+	"github.com/opsee/basic/schema"
+	"golang.org/x/net/context"
+)
+
+// requestTimer collects the timing of each phase of a single request. For
+// requests that are redirected, only the final request's timings are kept.
+type requestTimer struct {
+	sync.Mutex
+	dnsStart     time.Time
+	dnsDone      time.Time
+	connectStart time.Time
+	connectDone  time.Time
+	tlsStart     time.Time
+	tlsDone      time.Time
+	gotConn      time.Time
+	firstByte    time.Time
+	done         time.Time
+	remoteAddr   net.Addr
+	reused       bool
+}
+
+// reset clears the timer for a new request in a redirect chain.
+func (t *requestTimer) reset() {
+	t.Lock()
+	defer t.Unlock()
+
+	for _, field := range []*time.Time{&t.dnsStart, &t.dnsDone, &t.connectStart, &t.connectDone, &t.tlsStart, &t.tlsDone, &t.gotConn, &t.firstByte, &t.done} {
+		*field = time.Time{}
+	}
+	t.remoteAddr = nil
+	t.reused = false
+}
+
+func (t *requestTimer) mark(field *time.Time) {
+	t.Lock()
+	*field = time.Now()
+	t.Unlock()
+}
+
+// clientTrace returns an httptrace.ClientTrace that records into the timer.
+func (t *requestTimer) clientTrace() *httptrace.ClientTrace {
+	return &httptrace.ClientTrace{
+		GetConn:  func(_ string) { t.reset() },
+		DNSStart: func(_ httptrace.DNSStartInfo) { t.mark(&t.dnsStart) },
+		DNSDone:  func(_ httptrace.DNSDoneInfo) { t.mark(&t.dnsDone) },
+		ConnectStart: func(_, _ string) {
+			t.Lock()
+			// Only the first of several parallel dial attempts counts.
+			if t.connectStart.IsZero() {
+				t.connectStart = time.Now()
+			}
+			t.Unlock()
+		},
+		ConnectDone: func(_, _ string, err error) {
+			if err == nil {
+				t.mark(&t.connectDone)
+			}
+		},
+		TLSHandshakeStart: func() { t.mark(&t.tlsStart) },
+		TLSHandshakeDone:  func(_ tls.ConnectionState, _ error) { t.mark(&t.tlsDone) },
+		GotConn: func(info httptrace.GotConnInfo) {
+			t.Lock()
+			t.gotConn = time.Now()
+			t.remoteAddr = info.Conn.RemoteAddr()
+			t.reused = info.Reused
+			t.Unlock()
+		},
+		GotFirstResponseByte: func() { t.mark(&t.firstByte) },
+	}
+}
+
+// remoteIP returns the IP address of the server the request was sent to.
+func (t *requestTimer) remoteIP() string {
+	t.Lock()
+	defer t.Unlock()
+
+	if t.remoteAddr == nil {
+		return ""
+	}
+
+	host, _, err := net.SplitHostPort(t.remoteAddr.String())
+	if err != nil {
+		return t.remoteAddr.String()
+	}
+	return host
+}
+
+// connectionReused returns true if the request was sent over a previously
+// established connection.
+func (t *requestTimer) connectionReused() bool {
+	t.Lock()
+	defer t.Unlock()
+	return t.reused
+}
+
+func phaseMetric(name string, start, end time.Time) *schema.Metric {
+	return &schema.Metric{
+		Name:  name,
+		Value: end.Sub(start).Seconds() * 1000,
+		Unit:  "ms",
+	}
+}
+
+// metrics returns a Metric for each phase of the request that completed.
+// Phases that did not happen, e.g. DNS for a request made to an IP address,
+// are omitted.
+func (t *requestTimer) metrics() []*schema.Metric {
+	t.Lock()
+	defer t.Unlock()
+
+	phases := []struct {
+		name       string
+		start, end time.Time
+	}{
+		{"dns_lookup", t.dnsStart, t.dnsDone},
+		{"connect", t.connectStart, t.connectDone},
+		{"tls_handshake", t.tlsStart, t.tlsDone},
+		{"time_to_first_byte", t.gotConn, t.firstByte},
+		{"body_transfer", t.firstByte, t.done},
+	}
+
+	metrics := []*schema.Metric{}
+	for _, p := range phases {
+		if p.start.IsZero() || p.end.IsZero() {
+			continue
+		}
+		metrics = append(metrics, phaseMetric(p.name, p.start, p.end))
+	}
+
+	return metrics
+}
+
+// firstReadConn records when the first byte arrives on a connection.
+type firstReadConn struct {
+	net.Conn
+	timer *requestTimer
+	once  sync.Once
+}
+
+func (c *firstReadConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	if n > 0 {
+		c.once.Do(func() { c.timer.mark(&c.timer.firstByte) })
+	}
+	return n, err
+}
+
+// netDial returns a dial function for the WebSocket dialer that performs
+// DNS resolution, the TCP connect and, if tlsConfig is non-nil, the TLS
+// handshake itself so that each phase can be timed. Resolution and the
+// connect give up once the context is done.
+func (t *requestTimer) netDial(ctx context.Context, tlsConfig *tls.Config, timeout time.Duration) func(network, addr string) (net.Conn, error) {
+	return func(network, addr string) (net.Conn, error) {
+		host, port, err := net.SplitHostPort(addr)
+		if err != nil {
+			return nil, err
+		}
+
+		ip := host
+		if net.ParseIP(host) == nil {
+			t.mark(&t.dnsStart)
+			addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+			if err != nil {
+				return nil, err
+			}
+			t.mark(&t.dnsDone)
+			ip = addrs[0].IP.String()
+		}
+
+		t.mark(&t.connectStart)
+		dialer := &net.Dialer{Timeout: timeout}
+		conn, err := dialer.DialContext(ctx, network, net.JoinHostPort(ip, port))
+		if err != nil {
+			return nil, err
+		}
+		t.mark(&t.connectDone)
+
+		t.Lock()
+		t.remoteAddr = conn.RemoteAddr()
+		t.Unlock()
+
+		if tlsConfig != nil {
+			cfg := tlsConfig.Clone()
+			if cfg.ServerName == "" {
+				cfg.ServerName = host
+			}
+
+			t.mark(&t.tlsStart)
+			tlsConn := tls.Client(conn, cfg)
+			tlsConn.SetDeadline(time.Now().Add(timeout))
+			if err := tlsConn.Handshake(); err != nil {
+				conn.Close()
+				return nil, err
+			}
+			tlsConn.SetDeadline(time.Time{})
+			t.mark(&t.tlsDone)
+			conn = tlsConn
+		}
+
+		t.mark(&t.gotConn)
+		return &firstReadConn{Conn: conn, timer: t}, nil
+	}
+}
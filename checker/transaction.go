@@ -0,0 +1,294 @@
+package checker
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/opsee/basic/schema"
+	"github.com/opsee/bastion/errs"
+	"golang.org/x/net/context"
+)
+
+const (
+	httpTransactionWorkerTaskType = "HTTPTransactionRequest"
+)
+
+// HTTPTransactionRequest runs a sequence of HTTP requests against a single
+// target. Values captured from one step's response can be referenced in the
+// path, header values and body of any later step as {{name}}.
+type HTTPTransactionRequest struct {
+	Protocol           string                        `json:"protocol"`
+	Address            string                        `json:"address"`
+	Host               string                        `json:"host"`
+	InsecureSkipVerify bool                          `json:"insecure_skip_verify"`
+	Steps              []*schema.HttpTransactionStep `json:"steps"`
+}
+
+func init() {
+	Recruiters.RegisterWorker(httpTransactionWorkerTaskType, NewHTTPTransactionWorker)
+}
+
+// expand replaces every {{name}} in s with the captured value for name.
+func expand(s string, vars map[string]string) string {
+	if len(vars) == 0 || !strings.Contains(s, "{{") {
+		return s
+	}
+
+	oldnew := make([]string, 0, len(vars)*2)
+	for k, v := range vars {
+		oldnew = append(oldnew, fmt.Sprintf("{{%s}}", k), v)
+	}
+
+	return strings.NewReplacer(oldnew...).Replace(s)
+}
+
+func (r *HTTPTransactionRequest) stepRequest(step *schema.HttpTransactionStep, vars map[string]string) *HTTPRequest {
+	headers := make([]*schema.Header, 0, len(step.Headers))
+	for _, h := range step.Headers {
+		values := make([]string, len(h.Values))
+		for i, v := range h.Values {
+			values[i] = expand(v, vars)
+		}
+		headers = append(headers, &schema.Header{Name: h.Name, Values: values})
+	}
+
+	return &HTTPRequest{
+		Method:             step.Verb,
+		URL:                fmt.Sprintf("%s://%s%s", r.Protocol, r.Address, expand(step.Path, vars)),
+		Headers:            headers,
+		Body:               expand(step.Body, vars),
+		Host:               r.Host,
+		InsecureSkipVerify: r.InsecureSkipVerify,
+	}
+}
+
+// validateCaptures checks that every capture in the transaction has a name
+// and a known source.
+func validateCaptures(txn *schema.HttpTransactionCheck) error {
+	for i, step := range txn.GetSteps() {
+		for _, c := range step.Captures {
+			if c.Name == "" {
+				return fmt.Errorf("Capture in step %d has no name", i)
+			}
+			switch c.Source {
+			case "header", "json":
+			case "body":
+				if _, err := regexp.Compile(c.Key); err != nil {
+					return fmt.Errorf("Invalid body capture %s in step %d: %s", c.Name, i, err)
+				}
+			default:
+				return fmt.Errorf("Unknown capture source in step %d: %s", i, c.Source)
+			}
+		}
+	}
+
+	return nil
+}
+
+// capture extracts a single value from an HttpResponse as described by c.
+func capture(c *schema.Capture, resp *schema.HttpResponse) (string, error) {
+	switch c.Source {
+	case "header":
+		for _, h := range resp.Headers {
+			if strings.ToLower(h.Name) == strings.ToLower(c.Key) && len(h.Values) > 0 {
+				return h.Values[0], nil
+			}
+		}
+		return "", fmt.Errorf("header %s not found", c.Key)
+
+	case "body":
+		re, err := regexp.Compile(c.Key)
+		if err != nil {
+			return "", err
+		}
+		m := re.FindStringSubmatch(resp.Body)
+		switch {
+		case m == nil:
+			return "", fmt.Errorf("body does not match %s", c.Key)
+		case len(m) > 1:
+			return m[1], nil
+		default:
+			return m[0], nil
+		}
+
+	case "json":
+		var doc interface{}
+		if err := json.Unmarshal([]byte(resp.Body), &doc); err != nil {
+			return "", err
+		}
+		return jsonPath(doc, c.Key)
+	}
+
+	return "", fmt.Errorf("unknown capture source: %s", c.Source)
+}
+
+// jsonPath walks a decoded JSON document along a dotted path, e.g.
+// "data.items.0.id", and returns the value found there as a string.
+func jsonPath(doc interface{}, path string) (string, error) {
+	cur := doc
+	if path != "" {
+		for _, part := range strings.Split(path, ".") {
+			switch v := cur.(type) {
+			case map[string]interface{}:
+				next, ok := v[part]
+				if !ok {
+					return "", fmt.Errorf("json path %s not found", path)
+				}
+				cur = next
+			case []interface{}:
+				i, err := strconv.Atoi(part)
+				if err != nil || i < 0 || i >= len(v) {
+					return "", fmt.Errorf("json path %s not found", path)
+				}
+				cur = v[i]
+			default:
+				return "", fmt.Errorf("json path %s not found", path)
+			}
+		}
+	}
+
+	switch v := cur.(type) {
+	case string:
+		return v, nil
+	case nil:
+		return "", nil
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	default:
+		b, err := json.Marshal(v)
+		return string(b), err
+	}
+}
+
+func (r *HTTPTransactionRequest) Do(ctx context.Context) <-chan *Response {
+	respChan := make(chan *Response, 1)
+
+	go func() {
+		defer close(respChan)
+
+		var (
+			vars        = map[string]string{}
+			err         error
+			txnResponse = &schema.HttpTransactionResponse{
+				Steps:   []*schema.HttpTransactionStepResponse{},
+				Metrics: []*schema.Metric{},
+			}
+		)
+
+		t0 := time.Now()
+		for _, step := range r.Steps {
+			stepResponse := &schema.HttpTransactionStepResponse{
+				Name: step.Name,
+			}
+			txnResponse.Steps = append(txnResponse.Steps, stepResponse)
+
+			request := r.stepRequest(step, vars)
+			log.WithFields(log.Fields{"step": step.Name, "url": request.URL}).Debug("Running transaction step.")
+
+			t1 := time.Now()
+			var response *Response
+			select {
+			case response = <-request.Do(ctx):
+			case <-ctx.Done():
+				response = &Response{Error: ctx.Err()}
+			}
+
+			txnResponse.Metrics = append(txnResponse.Metrics, &schema.Metric{
+				Name:  "step_latency",
+				Value: time.Since(t1).Seconds() * 1000,
+				Unit:  "ms",
+				Tags: []*schema.Tag{
+					&schema.Tag{Name: "step", Value: step.Name},
+				},
+			})
+
+			if response.Error != nil {
+				err = response.Error
+			} else if reply, ok := response.Response.(*schema.CheckResponse_HttpResponse); ok {
+				stepResponse.Response = reply.HttpResponse
+				for _, c := range step.Captures {
+					v, cerr := capture(c, reply.HttpResponse)
+					if cerr != nil {
+						err = errs.Errorf(errs.Assertion, "capture %s: %s", c.Name, cerr.Error())
+						break
+					}
+					vars[c.Name] = v
+				}
+			} else {
+				err = fmt.Errorf("Unexpected response type: %T", response.Response)
+			}
+
+			if err != nil {
+				stepResponse.Error = err.Error()
+				err = errs.Wrapf(err, "step %s", step.Name)
+				break
+			}
+		}
+
+		txnResponse.Metrics = append(txnResponse.Metrics, &schema.Metric{
+			Name:  "request_latency",
+			Value: time.Since(t0).Seconds() * 1000,
+			Unit:  "ms",
+		})
+
+		respChan <- &Response{
+			Response: &schema.CheckResponse_HttpTransactionResponse{HttpTransactionResponse: txnResponse},
+			Error:    err,
+		}
+	}()
+
+	return respChan
+}
+
+type HTTPTransactionWorker struct {
+	workerQueue chan Worker
+}
+
+func NewHTTPTransactionWorker(queue chan Worker) Worker {
+	return &HTTPTransactionWorker{
+		workerQueue: queue,
+	}
+}
+
+func (w *HTTPTransactionWorker) Work(ctx context.Context, task *Task) *Task {
+	defer func() {
+		w.workerQueue <- w
+	}()
+
+	if ctx.Err() != nil {
+		task.Response = &Response{
+			Error: ctx.Err(),
+		}
+		return task
+	}
+
+	request, ok := task.Request.(*HTTPTransactionRequest)
+	if ok {
+		log.Debug("request: ", request)
+		select {
+		case response := <-request.Do(ctx):
+			if response.Error != nil {
+				log.WithError(response.Error).Errorf("error processing request: %v", *task)
+			}
+			task.Response = response
+		case <-ctx.Done():
+			task.Response = &Response{
+				Error: ctx.Err(),
+			}
+		}
+	} else {
+		task.Response = &Response{
+			Error: fmt.Errorf("Unable to process request: %s", task.Request),
+		}
+	}
+
+	log.Debug("response: ", task.Response)
+	return task
+}
@@ -0,0 +1,142 @@
+package checker
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/opsee/basic/schema"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/context"
+)
+
+func transactionTestServer() *httptest.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/login", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("X-Session", "session-1")
+		fmt.Fprint(w, `{"data": {"tokens": [{"value": "secret-token"}]}}`)
+	})
+	mux.HandleFunc("/protected/session-1", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer secret-token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		fmt.Fprint(w, "order=1234;")
+	})
+	return httptest.NewServer(mux)
+}
+
+func TestTransactionCapturesVariables(t *testing.T) {
+	ts := transactionTestServer()
+	defer ts.Close()
+
+	request := &HTTPTransactionRequest{
+		Protocol: "http",
+		Address:  strings.TrimPrefix(ts.URL, "http://"),
+		Steps: []*schema.HttpTransactionStep{
+			&schema.HttpTransactionStep{
+				Name: "login",
+				Path: "/login",
+				Verb: "POST",
+				Body: `{"user": "opsee"}`,
+				Captures: []*schema.Capture{
+					&schema.Capture{Name: "token", Source: "json", Key: "data.tokens.0.value"},
+					&schema.Capture{Name: "session", Source: "header", Key: "x-session"},
+				},
+			},
+			&schema.HttpTransactionStep{
+				Name: "fetch",
+				Path: "/protected/{{session}}",
+				Verb: "GET",
+				Headers: []*schema.Header{
+					&schema.Header{Name: "Authorization", Values: []string{"Bearer {{token}}"}},
+				},
+				Captures: []*schema.Capture{
+					&schema.Capture{Name: "order", Source: "body", Key: `order=(\d+);`},
+				},
+			},
+		},
+	}
+
+	resp := <-request.Do(context.Background())
+	assert.NoError(t, resp.Error)
+
+	reply, ok := resp.Response.(*schema.CheckResponse_HttpTransactionResponse)
+	assert.True(t, ok)
+
+	steps := reply.HttpTransactionResponse.Steps
+	assert.Len(t, steps, 2)
+	assert.EqualValues(t, 200, steps[0].Response.Code)
+	assert.EqualValues(t, 200, steps[1].Response.Code)
+	assert.Equal(t, "order=1234;", steps[1].Response.Body)
+
+	// one step_latency per step and a request_latency for the whole transaction
+	metrics := reply.HttpTransactionResponse.Metrics
+	assert.Len(t, metrics, 3)
+	assert.Equal(t, "step_latency", metrics[0].Name)
+	assert.Equal(t, "login", metrics[0].Tags[0].Value)
+	assert.Equal(t, "request_latency", metrics[2].Name)
+}
+
+func TestTransactionStopsOnFailedCapture(t *testing.T) {
+	ts := transactionTestServer()
+	defer ts.Close()
+
+	request := &HTTPTransactionRequest{
+		Protocol: "http",
+		Address:  strings.TrimPrefix(ts.URL, "http://"),
+		Steps: []*schema.HttpTransactionStep{
+			&schema.HttpTransactionStep{
+				Name: "login",
+				Path: "/login",
+				Verb: "POST",
+				Captures: []*schema.Capture{
+					&schema.Capture{Name: "token", Source: "json", Key: "data.missing"},
+				},
+			},
+			&schema.HttpTransactionStep{
+				Name: "fetch",
+				Path: "/protected/{{token}}",
+				Verb: "GET",
+			},
+		},
+	}
+
+	resp := <-request.Do(context.Background())
+	assert.Error(t, resp.Error)
+
+	reply, ok := resp.Response.(*schema.CheckResponse_HttpTransactionResponse)
+	assert.True(t, ok)
+	assert.Len(t, reply.HttpTransactionResponse.Steps, 1)
+	assert.NotEmpty(t, reply.HttpTransactionResponse.Steps[0].Error)
+}
+
+func TestValidateCaptures(t *testing.T) {
+	txn := &schema.HttpTransactionCheck{
+		Steps: []*schema.HttpTransactionStep{
+			{Captures: []*schema.Capture{
+				{Name: "token", Source: "json", Key: "data.token"},
+				{Name: "session", Source: "header", Key: "Set-Cookie"},
+				{Name: "id", Source: "body", Key: `id=(\d+)`},
+			}},
+		},
+	}
+	assert.NoError(t, validateCaptures(txn))
+	assert.NoError(t, validateCaptures(nil))
+
+	for _, c := range []*schema.Capture{
+		{Name: "token", Source: "xml", Key: "token"},
+		{Name: "token", Key: "token"},
+		{Source: "json", Key: "token"},
+		{Name: "id", Source: "body", Key: "id=("},
+	} {
+		txn.Steps[0].Captures = []*schema.Capture{c}
+		assert.Error(t, validateCaptures(txn), "%v", c)
+	}
+}
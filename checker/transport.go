@@ -0,0 +1,91 @@
+package checker
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// PooledMaxIdleConnsPerHost is the number of idle keep-alive connections
+	// a pooled transport keeps open to each host.
+	PooledMaxIdleConnsPerHost = 2
+	// PooledIdleConnTimeout is how long an idle keep-alive connection is kept
+	// open. Transports that have not been used for this long are discarded.
+	PooledIdleConnTimeout = 90 * time.Second
+)
+
+// newTransport returns the transport used for HTTP checks. It sets no
+// timeouts of its own: connecting and waiting for the response are bounded by
+// the deadline of the request's context, which is the check's timeout.
+func newTransport(tlsConfig *tls.Config) *http.Transport {
+	return &http.Transport{
+		TLSClientConfig: tlsConfig,
+		// DialContext (rather than Dial) so that connects are traced.
+		DialContext: (&net.Dialer{}).DialContext,
+	}
+}
+
+type pooledTransport struct {
+	transport *http.Transport
+	lastUsed  time.Time
+}
+
+// transportPool holds keep-alive transports for an HTTPWorker. Transports are
+// keyed by host and TLS settings, since connections made with one set of TLS
+// settings must not be reused for requests with another.
+type transportPool struct {
+	sync.Mutex
+	transports map[string]*pooledTransport
+}
+
+func newTransportPool() *transportPool {
+	return &transportPool{
+		transports: make(map[string]*pooledTransport),
+	}
+}
+
+// transportKey identifies the transport a request may share.
+func transportKey(urlHost string, tlsConfig *tls.Config, auth string) string {
+	h := sha256.Sum256([]byte(auth))
+	return strings.Join([]string{
+		urlHost,
+		tlsConfig.ServerName,
+		strconv.FormatBool(tlsConfig.InsecureSkipVerify),
+		hex.EncodeToString(h[:]),
+	}, "|")
+}
+
+// get returns the pooled transport for key, creating one with tlsConfig if
+// needed. Transports that have sat unused past PooledIdleConnTimeout are
+// closed and discarded.
+func (p *transportPool) get(key string, tlsConfig *tls.Config) *http.Transport {
+	p.Lock()
+	defer p.Unlock()
+
+	now := time.Now()
+	for k, t := range p.transports {
+		if k != key && now.Sub(t.lastUsed) > PooledIdleConnTimeout {
+			t.transport.CloseIdleConnections()
+			delete(p.transports, k)
+		}
+	}
+
+	t, ok := p.transports[key]
+	if !ok {
+		transport := newTransport(tlsConfig)
+		transport.MaxIdleConnsPerHost = PooledMaxIdleConnsPerHost
+		transport.IdleConnTimeout = PooledIdleConnTimeout
+		t = &pooledTransport{transport: transport}
+		p.transports[key] = t
+	}
+	t.lastUsed = now
+
+	return t.transport
+}
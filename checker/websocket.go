@@ -0,0 +1,366 @@
+package checker
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/opsee/basic/schema"
+	"github.com/opsee/bastion/errs"
+	"golang.org/x/net/context"
+)
+
+const (
+	webSocketSent     = "sent"
+	webSocketReceived = "received"
+)
+
+var webSocketFrameTypes = map[int]string{
+	websocket.TextMessage:   "text",
+	websocket.BinaryMessage: "binary",
+	websocket.PingMessage:   "ping",
+	websocket.PongMessage:   "pong",
+}
+
+// webSocketSteps returns the script for a WebSocket request. Requests without
+// one send their body, if they have one, and expect a single message back.
+func (r *HTTPRequest) webSocketSteps() []*schema.WebSocketStep {
+	if steps := r.WebSocket.GetSteps(); len(steps) > 0 {
+		return steps
+	}
+
+	steps := []*schema.WebSocketStep{}
+	if r.Body != "" {
+		steps = append(steps, &schema.WebSocketStep{Action: "send", Data: r.Body})
+	}
+	return append(steps, &schema.WebSocketStep{Action: "expect"})
+}
+
+// webSocketReadTimeout returns how long to wait for each expected frame. If
+// the request doesn't set one, frames may be waited for until the context's
+// deadline.
+func (r *HTTPRequest) webSocketReadTimeout(ctx context.Context) time.Duration {
+	if r.WebSocket != nil && r.WebSocket.ReadTimeout > 0 {
+		return withinDeadline(ctx, time.Duration(r.WebSocket.ReadTimeout)*time.Millisecond)
+	}
+	return withinDeadline(ctx, DefaultCheckTimeout)
+}
+
+// webSocketEvent is a frame, or an error, read from a WebSocket connection.
+type webSocketEvent struct {
+	messageType int
+	data        []byte
+	at          time.Time
+	err         error
+}
+
+// webSocketConversation carries out a scripted exchange over a WebSocket
+// connection, recording every frame sent and received.
+//
+// The connection is read by a single goroutine that delivers data frames and
+// the control frames seen by the ping and pong handlers, in the order they
+// arrived. Steps consume these with a timeout, so a silent server can't block
+// the check, and give up once the context is done.
+type webSocketConversation struct {
+	ctx         context.Context
+	conn        *websocket.Conn
+	readTimeout time.Duration
+	events      chan *webSocketEvent
+	done        chan struct{}
+	readErr     error
+	// data frames that arrived while waiting for a pong
+	pending []*webSocketEvent
+
+	frames      []*schema.WebSocketFrame
+	metrics     []*schema.Metric
+	lastSent    time.Time
+	lastMessage []byte
+}
+
+func newWebSocketConversation(ctx context.Context, conn *websocket.Conn, readTimeout time.Duration) *webSocketConversation {
+	wc := &webSocketConversation{
+		ctx:         ctx,
+		conn:        conn,
+		readTimeout: readTimeout,
+		events:      make(chan *webSocketEvent),
+		done:        make(chan struct{}),
+	}
+
+	conn.SetPingHandler(func(data string) error {
+		wc.deliver(&webSocketEvent{messageType: websocket.PingMessage, data: []byte(data), at: time.Now()})
+		err := conn.WriteControl(websocket.PongMessage, []byte(data), time.Now().Add(readTimeout))
+		if err == websocket.ErrCloseSent {
+			return nil
+		}
+		return err
+	})
+	conn.SetPongHandler(func(data string) error {
+		wc.deliver(&webSocketEvent{messageType: websocket.PongMessage, data: []byte(data), at: time.Now()})
+		return nil
+	})
+
+	go wc.read()
+
+	return wc
+}
+
+func (wc *webSocketConversation) read() {
+	for {
+		messageType, data, err := wc.conn.ReadMessage()
+		if !wc.deliver(&webSocketEvent{messageType: messageType, data: data, at: time.Now(), err: err}) || err != nil {
+			return
+		}
+	}
+}
+
+// deliver hands an event to the conversation. It returns false if the
+// conversation has ended.
+func (wc *webSocketConversation) deliver(e *webSocketEvent) bool {
+	select {
+	case wc.events <- e:
+		return true
+	case <-wc.done:
+		return false
+	}
+}
+
+// close ends the conversation. The caller must close the connection to stop
+// the reading goroutine.
+func (wc *webSocketConversation) close() {
+	close(wc.done)
+}
+
+// validateWebSocketScript checks that every step of the script is a known
+// action, with a frame type and match that can be carried out.
+func validateWebSocketScript(script *schema.WebSocketScript) error {
+	for i, step := range script.GetSteps() {
+		switch step.Action {
+		case "send", "expect", "ping":
+		default:
+			return fmt.Errorf("Unknown WebSocket step action in step %d: %s", i, step.Action)
+		}
+		if _, _, err := payload(step); err != nil {
+			return fmt.Errorf("Invalid WebSocket step %d: %s", i, err)
+		}
+
+		switch step.Match {
+		case "", "exact", "contains":
+		case "regex":
+			if _, err := regexp.Compile(step.Data); err != nil {
+				return fmt.Errorf("Invalid WebSocket step %d: %s", i, err)
+			}
+		default:
+			return fmt.Errorf("Unknown WebSocket match in step %d: %s", i, step.Match)
+		}
+	}
+
+	return nil
+}
+
+// run carries out each step in order, stopping at the first that fails.
+func (wc *webSocketConversation) run(steps []*schema.WebSocketStep) error {
+	for i, step := range steps {
+		var err error
+		switch step.Action {
+		case "send":
+			err = wc.send(step)
+		case "expect":
+			err = wc.expect(i, step)
+		case "ping":
+			err = wc.ping(i, step)
+		default:
+			err = fmt.Errorf("Unknown WebSocket step action: %s", step.Action)
+		}
+
+		if err != nil {
+			return errs.Wrapf(err, "WebSocket step %d (%s)", i, step.Action)
+		}
+	}
+
+	return nil
+}
+
+// payload returns the message type and data of a step.
+func payload(step *schema.WebSocketStep) (int, []byte, error) {
+	switch step.FrameType {
+	case "", "text":
+		return websocket.TextMessage, []byte(step.Data), nil
+	case "binary":
+		data, err := base64.StdEncoding.DecodeString(step.Data)
+		return websocket.BinaryMessage, data, err
+	default:
+		return 0, nil, fmt.Errorf("Unknown WebSocket frame type: %s", step.FrameType)
+	}
+}
+
+func (wc *webSocketConversation) record(direction string, messageType int, data []byte, at time.Time) {
+	frame := &schema.WebSocketFrame{
+		Direction: direction,
+		Type:      webSocketFrameTypes[messageType],
+	}
+
+	if messageType == websocket.BinaryMessage {
+		frame.Data = base64.StdEncoding.EncodeToString(data)
+	} else {
+		frame.Data = string(data)
+	}
+
+	if direction == webSocketSent {
+		wc.lastSent = at
+	} else if !wc.lastSent.IsZero() {
+		frame.Latency = at.Sub(wc.lastSent).Seconds() * 1000
+	}
+
+	wc.frames = append(wc.frames, frame)
+}
+
+func (wc *webSocketConversation) roundTrip(step int, sent, received time.Time) {
+	wc.metrics = append(wc.metrics, &schema.Metric{
+		Name:  "round_trip_latency",
+		Value: received.Sub(sent).Seconds() * 1000,
+		Unit:  "ms",
+		Tags: []*schema.Tag{
+			&schema.Tag{Name: "step", Value: strconv.Itoa(step)},
+		},
+	})
+}
+
+// receive returns the next event read from the connection, recording it.
+func (wc *webSocketConversation) receive() (*webSocketEvent, error) {
+	if wc.readErr != nil {
+		return nil, wc.readErr
+	}
+
+	timer := time.NewTimer(wc.readTimeout)
+	defer timer.Stop()
+
+	select {
+	case e := <-wc.events:
+		if e.err != nil {
+			wc.readErr = e.err
+			return nil, e.err
+		}
+		wc.record(webSocketReceived, e.messageType, e.data, e.at)
+		return e, nil
+	case <-timer.C:
+		return nil, errs.New(errs.Timeout, "Timed out waiting for WebSocket message.")
+	case <-wc.ctx.Done():
+		return nil, wc.ctx.Err()
+	}
+}
+
+// nextMessage returns the next text or binary message.
+func (wc *webSocketConversation) nextMessage() (*webSocketEvent, error) {
+	if len(wc.pending) > 0 {
+		e := wc.pending[0]
+		wc.pending = wc.pending[1:]
+		return e, nil
+	}
+
+	for {
+		e, err := wc.receive()
+		if err != nil {
+			return nil, err
+		}
+		if e.messageType == websocket.TextMessage || e.messageType == websocket.BinaryMessage {
+			return e, nil
+		}
+	}
+}
+
+func (wc *webSocketConversation) send(step *schema.WebSocketStep) error {
+	messageType, data, err := payload(step)
+	if err != nil {
+		return err
+	}
+
+	if err := wc.conn.SetWriteDeadline(time.Now().Add(withinDeadline(wc.ctx, wc.readTimeout))); err != nil {
+		return err
+	}
+
+	if err := wc.conn.WriteMessage(messageType, data); err != nil {
+		return err
+	}
+	wc.record(webSocketSent, messageType, data, time.Now())
+
+	return nil
+}
+
+func (wc *webSocketConversation) expect(i int, step *schema.WebSocketStep) error {
+	sent := wc.lastSent
+
+	e, err := wc.nextMessage()
+	if err != nil {
+		return err
+	}
+	wc.lastMessage = e.data
+
+	if !sent.IsZero() {
+		wc.roundTrip(i, sent, e.at)
+	}
+
+	if step.Data == "" {
+		return nil
+	}
+
+	messageType, expected, err := payload(step)
+	if err != nil {
+		return err
+	}
+
+	if step.FrameType != "" && messageType != e.messageType {
+		return errs.Errorf(errs.Assertion, "Expected a %s message, received %s.", webSocketFrameTypes[messageType], webSocketFrameTypes[e.messageType])
+	}
+
+	var matched bool
+	switch step.Match {
+	case "", "exact":
+		matched = bytes.Equal(e.data, expected)
+	case "contains":
+		matched = bytes.Contains(e.data, expected)
+	case "regex":
+		re, err := regexp.Compile(step.Data)
+		if err != nil {
+			return err
+		}
+		matched = re.Match(e.data)
+	default:
+		return fmt.Errorf("Unknown WebSocket match: %s", step.Match)
+	}
+
+	if !matched {
+		return errs.New(errs.Assertion, "Received message did not match.")
+	}
+
+	return nil
+}
+
+func (wc *webSocketConversation) ping(i int, step *schema.WebSocketStep) error {
+	data := []byte(step.Data)
+	sent := time.Now()
+	if err := wc.conn.WriteControl(websocket.PingMessage, data, sent.Add(wc.readTimeout)); err != nil {
+		return err
+	}
+	wc.record(webSocketSent, websocket.PingMessage, data, sent)
+
+	for {
+		e, err := wc.receive()
+		if err != nil {
+			return err
+		}
+
+		switch e.messageType {
+		case websocket.PongMessage:
+			if bytes.Equal(e.data, data) {
+				wc.roundTrip(i, sent, e.at)
+				return nil
+			}
+		case websocket.TextMessage, websocket.BinaryMessage:
+			wc.pending = append(wc.pending, e)
+		}
+	}
+}
@@ -0,0 +1,145 @@
+package checker
+
+import (
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+	"github.com/opsee/basic/schema"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/context"
+)
+
+// webSocketTestServer echoes every message it receives, after greeting the
+// client if greeting is set.
+func webSocketTestServer(greeting string) *httptest.Server {
+	upgrader := websocket.Upgrader{Subprotocols: []string{"chat", "echo"}}
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer c.Close()
+
+		if greeting != "" {
+			c.WriteMessage(websocket.TextMessage, []byte(greeting))
+		}
+
+		for {
+			mt, msg, err := c.ReadMessage()
+			if err != nil {
+				return
+			}
+			c.WriteMessage(mt, msg)
+		}
+	}))
+}
+
+func webSocketTestRequest(ts *httptest.Server, script *schema.WebSocketScript) (*schema.HttpResponse, error) {
+	requestMaker := &HTTPRequest{Method: "GET", URL: strings.Replace(ts.URL, "http", "ws", 1), WebSocket: script}
+	resp := <-requestMaker.Do(context.Background())
+	return resp.Response.(*schema.CheckResponse_HttpResponse).HttpResponse, resp.Error
+}
+
+func TestWebSocketScript(t *testing.T) {
+	ts := webSocketTestServer("welcome")
+	defer ts.Close()
+
+	binary := base64.StdEncoding.EncodeToString([]byte{0, 1, 2})
+	httpResponse, err := webSocketTestRequest(ts, &schema.WebSocketScript{
+		Subprotocols: []string{"echo"},
+		Steps: []*schema.WebSocketStep{
+			{Action: "expect", Data: "welcome"},
+			{Action: "send", Data: "hello there"},
+			{Action: "expect", Data: "there", Match: "contains"},
+			{Action: "ping", Data: "beat"},
+			{Action: "send", FrameType: "binary", Data: binary},
+			{Action: "expect", FrameType: "binary", Data: binary},
+			{Action: "send", Data: "bye"},
+			{Action: "expect", Data: "^b.e$", Match: "regex"},
+		},
+	})
+	assert.NoError(t, err)
+
+	assert.Equal(t, "echo", httpResponse.Subprotocol)
+	assert.Equal(t, "bye", httpResponse.Body)
+
+	frames := []string{}
+	for _, f := range httpResponse.WebsocketFrames {
+		frames = append(frames, f.Direction+" "+f.Type+" "+f.Data)
+	}
+	assert.Equal(t, []string{
+		"received text welcome",
+		"sent text hello there",
+		"received text hello there",
+		"sent ping beat",
+		"received pong beat",
+		"sent binary " + binary,
+		"received binary " + binary,
+		"sent text bye",
+		"received text bye",
+	}, frames)
+
+	rtts := 0
+	for _, m := range httpResponse.Metrics {
+		if m.Name == "round_trip_latency" {
+			rtts++
+		}
+	}
+	assert.Equal(t, 4, rtts)
+}
+
+func TestWebSocketExpectMismatch(t *testing.T) {
+	ts := webSocketTestServer("")
+	defer ts.Close()
+
+	httpResponse, err := webSocketTestRequest(ts, &schema.WebSocketScript{
+		Steps: []*schema.WebSocketStep{
+			{Action: "send", Data: "hello"},
+			{Action: "expect", Data: "goodbye"},
+		},
+	})
+	assert.Error(t, err)
+	assert.Len(t, httpResponse.WebsocketFrames, 2, "frames should be recorded for failed conversations")
+}
+
+func TestWebSocketReadTimeout(t *testing.T) {
+	ts := webSocketTestServer("")
+	defer ts.Close()
+
+	_, err := webSocketTestRequest(ts, &schema.WebSocketScript{
+		ReadTimeout: 50,
+		Steps: []*schema.WebSocketStep{
+			{Action: "expect"},
+		},
+	})
+	assert.Error(t, err)
+}
+
+func TestValidateWebSocketScript(t *testing.T) {
+	script := &schema.WebSocketScript{
+		Steps: []*schema.WebSocketStep{
+			{Action: "send", Data: "hello"},
+			{Action: "expect", Data: "^hel+o$", Match: "regex"},
+			{Action: "send", FrameType: "binary", Data: "aGVsbG8="},
+			{Action: "ping"},
+		},
+	}
+	assert.NoError(t, validateWebSocketScript(script))
+	assert.NoError(t, validateWebSocketScript(nil))
+
+	for _, step := range []*schema.WebSocketStep{
+		{Action: "receive"},
+		{},
+		{Action: "send", FrameType: "json"},
+		{Action: "send", FrameType: "binary", Data: "not base64!"},
+		{Action: "expect", Match: "fuzzy"},
+		{Action: "expect", Match: "regex", Data: "hel("},
+	} {
+		script.Steps = []*schema.WebSocketStep{step}
+		assert.Error(t, validateWebSocketScript(script), "%v", step)
+	}
+}
@@ -48,6 +48,12 @@ type Request interface {
 type Response struct {
 	Response schema.CheckResponseReply
 	Error    error
+	// Attempts is the number of requests made, and AttemptErrors the error
+	// of each, if the worker retried the request.
+	Attempts      int
+	AttemptErrors []string
+	// Diagnostics are gathered for failed HTTP requests that ask for them.
+	Diagnostics *schema.HttpDiagnostics
 }
 
 type Task struct {
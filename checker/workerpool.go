@@ -0,0 +1,376 @@
+package checker
+
+import (
+	"fmt"
+	"io/ioutil"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	log "github.com/Sirupsen/logrus"
+	metrics "github.com/rcrowley/go-metrics"
+	"golang.org/x/net/context"
+)
+
+const (
+	// MaxWorkersPerType is the most workers any worker pool may grow to.
+	MaxWorkersPerType = 100
+
+	// PoolResizeInterval is how often worker pools are resized.
+	PoolResizeInterval = 10 * time.Second
+	// PoolScaleUpWait is the mean queue wait above which a pool always grows.
+	PoolScaleUpWait = 50 * time.Millisecond
+	// PoolHeadroom is the factor by which a pool is sized above the number of
+	// workers its tasks kept busy on average.
+	PoolHeadroom = 1.25
+)
+
+// WorkerPoolConfig describes the size of the pool of workers for a task type.
+type WorkerPoolConfig struct {
+	// Size is the number of workers the pool starts with.
+	Size int
+	// MinSize and MaxSize bound the pool as it's resized.
+	MinSize int
+	MaxSize int
+}
+
+// DefaultWorkerPoolConfig applies to task types without their own config.
+var DefaultWorkerPoolConfig = WorkerPoolConfig{
+	Size:    MaxRoutinesPerWorkerType,
+	MinSize: MaxRoutinesPerWorkerType / 2,
+	MaxSize: MaxRoutinesPerWorkerType * 4,
+}
+
+// WorkerPoolConfigs holds the pool config for individual task types, and is
+// read when a Dispatcher is created. Use Dispatcher.ResizePool to change a
+// pool at runtime.
+var WorkerPoolConfigs = map[string]WorkerPoolConfig{}
+
+func workerPoolConfig(taskType string) WorkerPoolConfig {
+	if cfg, ok := WorkerPoolConfigs[taskType]; ok {
+		return cfg
+	}
+	return DefaultWorkerPoolConfig
+}
+
+// ParseWorkerPoolConfigs parses a list of task type pool configs, separated
+// by commas or whitespace, e.g. "HTTPRequest=20:10:80 CloudWatchRequest=4".
+// Each is the pool's size, optionally followed by its minimum and maximum
+// sizes. Without them, the pool's bounds are in the same proportion to its
+// size as the default's.
+func ParseWorkerPoolConfigs(s string) (map[string]WorkerPoolConfig, error) {
+	cfgs := make(map[string]WorkerPoolConfig)
+	fields := strings.FieldsFunc(s, func(r rune) bool {
+		return r == ',' || unicode.IsSpace(r)
+	})
+
+	for _, field := range fields {
+		parts := strings.SplitN(field, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("Invalid worker pool config: %s", field)
+		}
+		if _, ok := Recruiters.Get(parts[0]); !ok {
+			return nil, fmt.Errorf("No workers for task type %s.", parts[0])
+		}
+
+		sizes := []int{}
+		for _, size := range strings.Split(parts[1], ":") {
+			n, err := strconv.Atoi(size)
+			if err != nil || n < 1 {
+				return nil, fmt.Errorf("Invalid worker pool size in %s", field)
+			}
+			sizes = append(sizes, n)
+		}
+
+		var cfg WorkerPoolConfig
+		switch len(sizes) {
+		case 1:
+			cfg = WorkerPoolConfig{Size: sizes[0], MinSize: sizes[0] / 2, MaxSize: sizes[0] * 4}
+		case 3:
+			cfg = WorkerPoolConfig{Size: sizes[0], MinSize: sizes[1], MaxSize: sizes[2]}
+		default:
+			return nil, fmt.Errorf("Invalid worker pool config: %s", field)
+		}
+		cfgs[parts[0]] = cfg
+	}
+
+	return cfgs, nil
+}
+
+// LoadWorkerPoolConfigs reads a file of worker pool configs, in the format
+// ParseWorkerPoolConfigs parses.
+func LoadWorkerPoolConfigs(path string) (map[string]WorkerPoolConfig, error) {
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParseWorkerPoolConfigs(string(contents))
+}
+
+// Usage of the flags that runners set worker pool configs with.
+const (
+	WorkerPoolConfigsUsage     = "Worker pool sizes per task type, as type=size[:min:max], e.g. HTTPRequest=20:10:80,CloudWatchRequest=4."
+	WorkerPoolConfigsFileUsage = "File of worker pool sizes, in the format of pool_sizes, applied after them at startup and again on SIGHUP."
+)
+
+// WorkerPoolConfigsFile is a file of worker pool configs that is applied
+// over those given to SetWorkerPoolConfigs, and again by
+// NSQRunner.ReloadWorkerPools.
+var WorkerPoolConfigsFile string
+
+// SetWorkerPoolConfigs sets WorkerPoolConfigs from s, in the format
+// ParseWorkerPoolConfigs parses, and then from WorkerPoolConfigsFile if it is
+// set.
+func SetWorkerPoolConfigs(s string) error {
+	cfgs, err := ParseWorkerPoolConfigs(s)
+	if err != nil {
+		return err
+	}
+
+	if WorkerPoolConfigsFile != "" {
+		fileCfgs, err := LoadWorkerPoolConfigs(WorkerPoolConfigsFile)
+		if err != nil {
+			return err
+		}
+		for taskType, cfg := range fileCfgs {
+			cfgs[taskType] = cfg
+		}
+	}
+
+	WorkerPoolConfigs = cfgs
+	return nil
+}
+
+// A workerGroup holds the pool of workers for a task type. Before taking a
+// worker, a task must acquire a token from its lane's reservation or from the
+// shared pool, so there are never more tokens than free workers. The pool is
+// shrunk by withholding shared tokens, and grown by adding workers along with
+// their tokens.
+type workerGroup struct {
+	sync.Mutex
+	WorkerQueue chan Worker
+	workerFunc  NewWorkerFunc
+	shared      chan struct{}
+	reserved    map[string]chan struct{}
+
+	size          int
+	reservedSize  int
+	minSize       int
+	maxSize       int
+	pendingShrink int
+	busy          int
+
+	// collected since the pool was last resized
+	lastResize time.Time
+	tasks      int
+	waited     time.Duration
+	worked     time.Duration
+
+	sizeGauge    metrics.Gauge
+	busyGauge    metrics.Gauge
+	waitedMillis metrics.Histogram
+}
+
+func newWorkerGroup(taskType string, workerFunc NewWorkerFunc, cfg WorkerPoolConfig, registry metrics.Registry) *workerGroup {
+	wg := &workerGroup{
+		workerFunc:   workerFunc,
+		WorkerQueue:  make(chan Worker, MaxWorkersPerType),
+		shared:       make(chan struct{}, MaxWorkersPerType),
+		reserved:     make(map[string]chan struct{}),
+		lastResize:   time.Now(),
+		sizeGauge:    metrics.GetOrRegisterGauge(fmt.Sprintf("%s.pool_size", taskType), registry),
+		busyGauge:    metrics.GetOrRegisterGauge(fmt.Sprintf("%s.busy_workers", taskType), registry),
+		waitedMillis: metrics.GetOrRegisterHistogram(fmt.Sprintf("%s.queue_wait", taskType), registry, metrics.NewExpDecaySample(1028, 0.015)),
+	}
+
+	size := clampPoolSize(cfg.Size, 1, MaxWorkersPerType)
+
+	// Always leave at least one worker for the shared pool.
+	available := int(float64(size) * MaxReservedFraction)
+	if available > size-1 {
+		available = size - 1
+	}
+	for _, reservation := range LaneReservations {
+		n := reservation.Workers
+		if n > available {
+			n = available
+		}
+		if n <= 0 {
+			continue
+		}
+		available -= n
+
+		tokens := make(chan struct{}, n)
+		for i := 0; i < n; i++ {
+			wg.WorkerQueue <- workerFunc(wg.WorkerQueue)
+			tokens <- struct{}{}
+		}
+		wg.reserved[reservation.Lane] = tokens
+		wg.reservedSize += n
+		wg.size += n
+	}
+
+	wg.setBounds(cfg.MinSize, cfg.MaxSize)
+	wg.resize(size)
+
+	return wg
+}
+
+func clampPoolSize(size, min, max int) int {
+	if size < min {
+		return min
+	}
+	if size > max {
+		return max
+	}
+	return size
+}
+
+// setBounds sets the pool's size limits. The caller must hold the lock, or
+// own the pool exclusively.
+func (wg *workerGroup) setBounds(min, max int) {
+	// Reserved workers are never removed, and there's always a shared one.
+	wg.minSize = clampPoolSize(min, wg.reservedSize+1, MaxWorkersPerType)
+	wg.maxSize = clampPoolSize(max, wg.minSize, MaxWorkersPerType)
+}
+
+// resize grows or shrinks the shared part of the pool so that it has size
+// workers in total. Workers that are busy are removed once they're done. The
+// caller must hold the lock, or own the pool exclusively.
+func (wg *workerGroup) resize(size int) {
+	size = clampPoolSize(size, wg.minSize, wg.maxSize)
+
+	// Cancel shrinks that haven't happened yet before growing.
+	for wg.size < size && wg.pendingShrink > 0 {
+		wg.pendingShrink--
+		wg.size++
+	}
+	for wg.size < size {
+		wg.WorkerQueue <- wg.workerFunc(wg.WorkerQueue)
+		wg.shared <- struct{}{}
+		wg.size++
+	}
+
+	for wg.size > size {
+		select {
+		case <-wg.shared:
+			<-wg.WorkerQueue
+		default:
+			wg.pendingShrink++
+		}
+		wg.size--
+	}
+
+	wg.sizeGauge.Update(int64(wg.size))
+}
+
+// autoResize sizes the pool to fit its recent load. From how long tasks
+// took, it works out how many workers were busy on average and adds some
+// headroom. If tasks waited too long for a worker, the pool grows regardless.
+// Pools shrink by one worker at a time.
+func (wg *workerGroup) autoResize(now time.Time) {
+	wg.Lock()
+	defer wg.Unlock()
+
+	elapsed := now.Sub(wg.lastResize)
+	if elapsed < PoolResizeInterval {
+		return
+	}
+
+	// Idle pools need no workers.
+	target := 0
+	if wg.tasks > 0 {
+		// The time spent working on tasks per unit time is the mean number
+		// of busy workers.
+		target = int(math.Ceil(wg.worked.Seconds() / elapsed.Seconds() * PoolHeadroom))
+
+		if wg.waited/time.Duration(wg.tasks) > PoolScaleUpWait && target <= wg.size {
+			target = wg.size + 1
+		}
+	}
+	if target < wg.size {
+		target = wg.size - 1
+	}
+
+	if target != wg.size {
+		log.WithFields(log.Fields{"from": wg.size, "to": target, "tasks": wg.tasks}).Debug("Resizing worker pool.")
+		wg.resize(target)
+	}
+
+	wg.lastResize = now
+	wg.tasks = 0
+	wg.waited = 0
+	wg.worked = 0
+}
+
+// acquire blocks until the lane may use a worker, preferring the lane's
+// reserved capacity to the shared pool, and returns the worker. The returned
+// function must be called with the time the worker spent on the task, once
+// it's done.
+func (wg *workerGroup) acquire(ctx context.Context, lane string) (Worker, func(time.Duration), error) {
+	if err := ctx.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	queued := time.Now()
+
+	// A nil channel (for a lane without a reservation) is never ready.
+	reserved := wg.reserved[lane]
+	var tokens chan struct{}
+
+	select {
+	case <-reserved:
+		tokens = reserved
+	default:
+		select {
+		case <-reserved:
+			tokens = reserved
+		case <-wg.shared:
+			tokens = wg.shared
+		case <-ctx.Done():
+			return nil, nil, ctx.Err()
+		}
+	}
+
+	// Holding a token means a worker is free, or about to be returned to the
+	// queue.
+	var w Worker
+	select {
+	case w = <-wg.WorkerQueue:
+	case <-ctx.Done():
+		tokens <- struct{}{}
+		return nil, nil, ctx.Err()
+	}
+
+	waited := time.Since(queued)
+	wg.waitedMillis.Update(int64(waited / time.Millisecond))
+
+	wg.Lock()
+	wg.busy++
+	wg.tasks++
+	wg.waited += waited
+	wg.busyGauge.Update(int64(wg.busy))
+	wg.Unlock()
+
+	release := func(worked time.Duration) {
+		wg.Lock()
+		defer wg.Unlock()
+
+		wg.busy--
+		wg.worked += worked
+		wg.busyGauge.Update(int64(wg.busy))
+
+		if tokens == wg.shared && wg.pendingShrink > 0 {
+			// The worker has already put itself back on the queue.
+			<-wg.WorkerQueue
+			wg.pendingShrink--
+			return
+		}
+		tokens <- struct{}{}
+	}
+
+	return w, release, nil
+}
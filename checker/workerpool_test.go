@@ -0,0 +1,147 @@
+package checker
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	metrics "github.com/rcrowley/go-metrics"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/context"
+)
+
+func newTestWorkerGroup(cfg WorkerPoolConfig) *workerGroup {
+	return newWorkerGroup("test", newDispatcherBlockingWorker, cfg, metrics.NewRegistry())
+}
+
+func TestWorkerPoolResize(t *testing.T) {
+	wg := newTestWorkerGroup(WorkerPoolConfig{Size: 10, MinSize: 5, MaxSize: 20})
+	assert.Equal(t, 10, wg.size)
+	assert.Len(t, wg.WorkerQueue, 10)
+
+	wg.resize(15)
+	assert.Equal(t, 15, wg.size)
+	assert.Len(t, wg.WorkerQueue, 15)
+
+	wg.resize(100)
+	assert.Equal(t, 20, wg.size, "pools must not grow past their maximum")
+
+	wg.resize(1)
+	assert.Equal(t, 5, wg.size, "pools must not shrink below their minimum")
+	assert.Len(t, wg.WorkerQueue, 5)
+}
+
+func TestWorkerPoolShrinkBusy(t *testing.T) {
+	wg := newTestWorkerGroup(WorkerPoolConfig{Size: 6, MinSize: 5, MaxSize: 6})
+
+	// Keep every shared worker busy.
+	ctx := context.Background()
+	releases := []func(time.Duration){}
+	for i := 0; i < 6-wg.reservedSize; i++ {
+		w, release, err := wg.acquire(ctx, LaneScheduled)
+		assert.NoError(t, err)
+		wg.WorkerQueue <- w
+		releases = append(releases, release)
+	}
+
+	wg.Lock()
+	wg.resize(5)
+	wg.Unlock()
+	assert.Equal(t, 5, wg.size)
+	assert.Equal(t, 1, wg.pendingShrink)
+
+	for _, release := range releases {
+		release(time.Millisecond)
+	}
+	assert.Equal(t, 0, wg.pendingShrink)
+	assert.Len(t, wg.WorkerQueue, 5)
+	assert.Len(t, wg.shared, 5-wg.reservedSize)
+}
+
+func TestWorkerPoolAutoResize(t *testing.T) {
+	wg := newTestWorkerGroup(WorkerPoolConfig{Size: 10, MinSize: 5, MaxSize: 40})
+
+	// 100 tasks per second taking 200ms each keep 20 workers busy.
+	now := wg.lastResize.Add(PoolResizeInterval)
+	wg.tasks = int(100 * PoolResizeInterval.Seconds())
+	wg.worked = time.Duration(wg.tasks) * 200 * time.Millisecond
+	wg.autoResize(now)
+	assert.Equal(t, 25, wg.size)
+
+	// Idle pools shrink a worker at a time.
+	wg.autoResize(now.Add(PoolResizeInterval))
+	assert.Equal(t, 24, wg.size)
+
+	// Tasks that wait too long grow the pool.
+	wg.tasks = 1
+	wg.worked = time.Millisecond
+	wg.waited = time.Second
+	wg.autoResize(now.Add(2 * PoolResizeInterval))
+	assert.Equal(t, 25, wg.size)
+}
+
+func TestParseWorkerPoolConfigs(t *testing.T) {
+	cfgs, err := ParseWorkerPoolConfigs("HTTPRequest=20:10:80,\nCloudWatchRequest=4 ")
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]WorkerPoolConfig{
+		httpWorkerTaskType:       {Size: 20, MinSize: 10, MaxSize: 80},
+		cloudwatchWorkerTaskType: {Size: 4, MinSize: 2, MaxSize: 16},
+	}, cfgs)
+
+	cfgs, err = ParseWorkerPoolConfigs("")
+	assert.NoError(t, err)
+	assert.Empty(t, cfgs)
+
+	for _, s := range []string{"HTTPRequest", "HTTPRequest=0", "HTTPRequest=10:5", "HTTPRequest=ten", "UnknownRequest=10"} {
+		_, err = ParseWorkerPoolConfigs(s)
+		assert.Error(t, err, s)
+	}
+}
+
+func TestWorkerPoolReservations(t *testing.T) {
+	wg := newTestWorkerGroup(WorkerPoolConfig{Size: 10, MinSize: 5, MaxSize: 40})
+	assert.Equal(t, 3, wg.reservedSize)
+	assert.Len(t, wg.reserved[LaneTest], 2)
+	assert.Len(t, wg.reserved[LaneRetry], 1)
+	assert.Len(t, wg.shared, 7)
+
+	// Lanes earlier in the order get their reservations first.
+	for i := 0; i < 10; i++ {
+		wg = newTestWorkerGroup(WorkerPoolConfig{Size: 4, MinSize: 1, MaxSize: 4})
+		assert.Equal(t, 1, wg.reservedSize)
+		assert.Len(t, wg.reserved[LaneTest], 1)
+		assert.Nil(t, wg.reserved[LaneRetry])
+	}
+
+	// Tiny pools reserve nothing.
+	wg = newTestWorkerGroup(WorkerPoolConfig{Size: 1, MinSize: 1, MaxSize: 1})
+	assert.Equal(t, 0, wg.reservedSize)
+	assert.Len(t, wg.shared, 1)
+}
+
+func TestSetWorkerPoolConfigs(t *testing.T) {
+	defer func(cfgs map[string]WorkerPoolConfig, file string) {
+		WorkerPoolConfigs = cfgs
+		WorkerPoolConfigsFile = file
+	}(WorkerPoolConfigs, WorkerPoolConfigsFile)
+
+	f, err := ioutil.TempFile("", "pool_sizes")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer os.Remove(f.Name())
+	f.WriteString("CloudWatchRequest=8:4:16\n")
+	f.Close()
+
+	// The file's configs are applied over those given.
+	WorkerPoolConfigsFile = f.Name()
+	assert.NoError(t, SetWorkerPoolConfigs("HTTPRequest=20:10:80,CloudWatchRequest=4"))
+	assert.Equal(t, map[string]WorkerPoolConfig{
+		httpWorkerTaskType:       {Size: 20, MinSize: 10, MaxSize: 80},
+		cloudwatchWorkerTaskType: {Size: 8, MinSize: 4, MaxSize: 16},
+	}, WorkerPoolConfigs)
+
+	WorkerPoolConfigsFile = f.Name() + ".missing"
+	assert.Error(t, SetWorkerPoolConfigs(""))
+}
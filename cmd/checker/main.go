@@ -6,6 +6,7 @@ import (
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials"
@@ -25,6 +26,7 @@ const (
 
 var (
 	adminPort      int
+	drainTimeout   time.Duration
 	signalsChannel = make(chan os.Signal, 1)
 )
 
@@ -39,10 +41,13 @@ func main() {
 	runnerConfig := &checker.NSQRunnerConfig{}
 
 	flag.StringVar(&runnerConfig.ConsumerQueueName, "results", "results", "Result queue name.")
-	flag.StringVar(&runnerConfig.ProducerQueueName, "requests", "runner", "Requests queue name.")
+	flag.StringVar(&runnerConfig.ProducerQueueName, "requests", checker.RunnerTopic, "Requests queue name. Checks of each type are published to <requests>_<type>.")
 	flag.StringVar(&runnerConfig.ConsumerChannelName, "channel", "runner", "Consumer channel name.")
 	flag.IntVar(&runnerConfig.MaxHandlers, "max_checks", 10, "Maximum concurrently executing checks.")
 	flag.IntVar(&adminPort, "admin_port", 4000, "Port for the admin server.")
+	flag.DurationVar(&drainTimeout, "drain_timeout", checker.DefaultDrainTimeout, "Time to allow in-flight requests to finish when stopping.")
+	flag.DurationVar(&checker.DefaultResolverCacheConfig.TTL, "resolver_cache_ttl", checker.DefaultResolverCacheConfig.TTL, "Time to use resolved targets before resolving them again, or 0 to not cache them.")
+	flag.DurationVar(&checker.DefaultResolverCacheConfig.MaxStale, "resolver_cache_max_stale", checker.DefaultResolverCacheConfig.MaxStale, "Time past resolver_cache_ttl that resolved targets may be used while they're refreshed, or if refreshing them fails.")
 	flag.Parse()
 
 	bezosConn, err := grpc.Dial(
@@ -61,7 +66,7 @@ func main() {
 	runnerConfig.ConsumerNsqdHost = cfg.NsqdHost
 	runnerConfig.ProducerNsqdHost = cfg.NsqdHost
 	log.WithFields(log.Fields{"service": moduleName}).Info("starting up")
-	resolver := checker.NewResolver(bezosClient, config.GetConfig())
+	resolver := checker.NewCachingResolver(checker.NewResolver(bezosClient, config.GetConfig()))
 	newChecker := checker.NewChecker(resolver)
 	runner, err := checker.NewRemoteRunner(runnerConfig)
 	if err != nil {
@@ -78,7 +83,6 @@ func main() {
 	}
 
 	scheduler.Producer = producer
-	defer newChecker.Stop()
 
 	newChecker.Port = adminPort
 	if err := newChecker.Start(); err != nil {
@@ -87,7 +91,6 @@ func main() {
 
 	portmapper.EtcdHost = cfg.EtcdHost
 	portmapper.Register(moduleName, newChecker.Port)
-	defer portmapper.Unregister(moduleName, newChecker.Port)
 
 	heart, err := heart.NewHeart(cfg.NsqdHost, moduleName)
 	if err != nil {
@@ -100,6 +103,9 @@ func main() {
 		case s := <-signalsChannel:
 			switch s {
 			case syscall.SIGTERM, syscall.SIGINT, syscall.SIGQUIT:
+				log.Info("Received signal ", s, ". Stopping.")
+				portmapper.Unregister(moduleName, newChecker.Port)
+				newChecker.GracefulStop(drainTimeout)
 				os.Exit(0)
 			}
 		case beatErr := <-beatChan:
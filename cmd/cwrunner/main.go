@@ -5,6 +5,7 @@ import (
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 
 	log "github.com/Sirupsen/logrus"
 	"github.com/opsee/basic/schema"
@@ -19,11 +20,13 @@ const (
 )
 
 var (
+	drainTimeout   time.Duration
+	poolSizes      string
 	signalsChannel = make(chan os.Signal, 1)
 )
 
 func init() {
-	signal.Notify(signalsChannel, syscall.SIGINT, syscall.SIGTERM, syscall.SIGQUIT)
+	signal.Notify(signalsChannel, syscall.SIGINT, syscall.SIGTERM, syscall.SIGQUIT, syscall.SIGHUP)
 }
 
 func main() {
@@ -32,9 +35,16 @@ func main() {
 	runnerConfig := &checker.NSQRunnerConfig{}
 	flag.StringVar(&runnerConfig.Id, "id", moduleName, "Runner identifier.")
 	flag.StringVar(&runnerConfig.ProducerQueueName, "results", "results", "Result queue name.")
-	flag.StringVar(&runnerConfig.ConsumerQueueName, "requests", "runner", "Requests queue name.")
+	flag.StringVar(&runnerConfig.ConsumerQueueName, "requests", checker.RunnerTopic, "Requests queue name. Checks are consumed from <requests>_cloudwatch.")
 	flag.StringVar(&runnerConfig.ConsumerChannelName, "channel", "cwrunner", "Consumer channel name.")
+	flag.StringVar(&runnerConfig.TargetTransitionQueueName, "target_transitions", "target_transitions", "Queue name for target state transitions, or empty to not publish them.")
 	flag.IntVar(&runnerConfig.MaxHandlers, "max_checks", 10, "Maximum concurrently executing checks.")
+	flag.IntVar(&checker.DefaultFlapConfig.Window, "flap_window", checker.DefaultFlapConfig.Window, "Number of recent results considered by flap detection.")
+	flag.Float64Var(&checker.DefaultFlapConfig.HighThreshold, "flap_high_threshold", checker.DefaultFlapConfig.HighThreshold, "Percent state change at which checks and targets start flapping.")
+	flag.Float64Var(&checker.DefaultFlapConfig.LowThreshold, "flap_low_threshold", checker.DefaultFlapConfig.LowThreshold, "Percent state change below which checks and targets stop flapping.")
+	flag.DurationVar(&drainTimeout, "drain_timeout", checker.DefaultDrainTimeout, "Time to allow running checks to finish when stopping.")
+	flag.StringVar(&poolSizes, "pool_sizes", "", checker.WorkerPoolConfigsUsage)
+	flag.StringVar(&checker.WorkerPoolConfigsFile, "pool_sizes_file", "", checker.WorkerPoolConfigsFileUsage)
 	flag.Parse()
 	runnerConfig.ConsumerNsqdHost = config.GetConfig().NsqdHost
 	runnerConfig.ProducerNsqdHost = config.GetConfig().NsqdHost
@@ -46,6 +56,12 @@ func main() {
 		log.Fatal(err)
 	}
 
+	if err := checker.SetWorkerPoolConfigs(poolSizes); err != nil {
+		log.Fatal(err.Error())
+	}
+
+	// cwrunner only runs CloudWatch checks, which are routed to it by topic.
+	// Runners leave them to it unless they're run with -types cloudwatch.
 	runner, err := checker.NewNSQRunner(checker.NewRunner(&schema.CloudWatchCheck{}), runnerConfig)
 	if err != nil {
 		log.Fatal(err.Error())
@@ -63,8 +79,10 @@ func main() {
 			switch s {
 			case syscall.SIGTERM, syscall.SIGINT, syscall.SIGQUIT:
 				log.Info("Received signal ", s, ". Stopping.")
-				runner.Stop()
+				runner.GracefulStop(drainTimeout)
 				os.Exit(0)
+			case syscall.SIGHUP:
+				runner.ReloadWorkerPools()
 			}
 		case beatErr := <-beatChan:
 			log.WithError(beatErr).Error("Heartbeat error.")
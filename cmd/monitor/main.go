@@ -53,7 +53,6 @@ func main() {
 	if err != nil {
 		log.WithError(err).Fatal("Unable to register service with portmapper.")
 	}
-	defer portmapper.Unregister(moduleName, adminPort)
 
 	// serve http forever
 	go func() {
@@ -74,6 +73,7 @@ func main() {
 			switch s {
 			case syscall.SIGTERM, syscall.SIGINT, syscall.SIGQUIT:
 				log.Info("Received signal ", s, ". Stopping.")
+				portmapper.Unregister(moduleName, adminPort)
 				os.Exit(0)
 			}
 		case beatErr := <-beatChan:
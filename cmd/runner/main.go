@@ -2,12 +2,15 @@ package main
 
 import (
 	"flag"
+	"fmt"
 	"os"
 	"os/signal"
+	"strconv"
+	"strings"
 	"syscall"
+	"time"
 
 	log "github.com/Sirupsen/logrus"
-	"github.com/opsee/basic/schema"
 	"github.com/opsee/bastion/checker"
 	"github.com/opsee/bastion/config"
 	"github.com/opsee/bastion/heart"
@@ -19,29 +22,89 @@ const (
 )
 
 var (
+	drainTimeout   time.Duration
+	poolSizes      string
 	signalsChannel = make(chan os.Signal, 1)
 )
 
 func init() {
-	signal.Notify(signalsChannel, syscall.SIGINT, syscall.SIGTERM, syscall.SIGQUIT)
+	signal.Notify(signalsChannel, syscall.SIGINT, syscall.SIGTERM, syscall.SIGQUIT, syscall.SIGHUP)
+}
+
+// parseMaxChecksPerType parses a list of type=limit pairs, e.g. "http=10,cloudwatch=4".
+func parseMaxChecksPerType(s string) (map[string]int, error) {
+	limits := make(map[string]int)
+	if s == "" {
+		return limits, nil
+	}
+
+	for _, pair := range strings.Split(s, ",") {
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("Invalid check type limit: %s", pair)
+		}
+		n, err := strconv.Atoi(parts[1])
+		if err != nil {
+			return nil, err
+		}
+		limits[strings.TrimSpace(parts[0])] = n
+	}
+
+	return limits, nil
 }
 
 func main() {
-	var err error
+	var (
+		err             error
+		checkTypes      string
+		maxChecksByType string
+	)
 
 	runnerConfig := &checker.NSQRunnerConfig{}
 	flag.StringVar(&runnerConfig.Id, "id", moduleName, "Runner identifier.")
 	flag.StringVar(&runnerConfig.ProducerQueueName, "results", "results", "Result queue name.")
-	flag.StringVar(&runnerConfig.ConsumerQueueName, "requests", "runner", "Requests queue name.")
+	flag.StringVar(&runnerConfig.ConsumerQueueName, "requests", checker.RunnerTopic, "Requests queue name. Checks of each type are consumed from <requests>_<type>.")
 	flag.StringVar(&runnerConfig.ConsumerChannelName, "channel", "runner", "Consumer channel name.")
-	flag.IntVar(&runnerConfig.MaxHandlers, "max_checks", 10, "Maximum concurrently executing checks.")
+	flag.StringVar(&runnerConfig.TargetTransitionQueueName, "target_transitions", "target_transitions", "Queue name for target state transitions, or empty to not publish them.")
+	flag.IntVar(&runnerConfig.MaxHandlers, "max_checks", 10, "Maximum concurrently executing checks of each type.")
+	flag.StringVar(&maxChecksByType, "max_checks_per_type", "", "Per check type overrides of max_checks, e.g. http=10,cloudwatch=4.")
+	// CloudWatch checks are left to cwrunner unless they're asked for, since
+	// every channel on their topic gets its own copy of each check.
+	flag.StringVar(&checkTypes, "types", checker.HTTPCheckType, fmt.Sprintf("Check types to run, of %s. Only run cloudwatch checks where cwrunner isn't.", strings.Join(checker.CheckTypes, ",")))
+	flag.IntVar(&checker.DefaultTargetLimits.MaxConcurrent, "target_max_concurrent", 0, "Maximum concurrent requests to any one target, or 0 for no limit.")
+	flag.Float64Var(&checker.DefaultTargetLimits.RequestsPerSecond, "target_rps", 0, "Maximum requests per second to any one target, or 0 for no limit.")
+	flag.BoolVar(&checker.DefaultTargetLimits.FailFast, "target_fail_fast", false, "Fail requests over the target limits instead of queueing them.")
+	flag.IntVar(&checker.DefaultFlapConfig.Window, "flap_window", checker.DefaultFlapConfig.Window, "Number of recent results considered by flap detection.")
+	flag.Float64Var(&checker.DefaultFlapConfig.HighThreshold, "flap_high_threshold", checker.DefaultFlapConfig.HighThreshold, "Percent state change at which checks and targets start flapping.")
+	flag.Float64Var(&checker.DefaultFlapConfig.LowThreshold, "flap_low_threshold", checker.DefaultFlapConfig.LowThreshold, "Percent state change below which checks and targets stop flapping.")
+	flag.DurationVar(&drainTimeout, "drain_timeout", checker.DefaultDrainTimeout, "Time to allow running checks to finish when stopping.")
+	flag.StringVar(&poolSizes, "pool_sizes", "", checker.WorkerPoolConfigsUsage)
+	flag.StringVar(&checker.WorkerPoolConfigsFile, "pool_sizes_file", "", checker.WorkerPoolConfigsFileUsage)
 	flag.Parse()
 	runnerConfig.ConsumerNsqdHost = config.GetConfig().NsqdHost
 	runnerConfig.ProducerNsqdHost = config.GetConfig().NsqdHost
 
+	runnerConfig.CheckTypes = strings.Split(checkTypes, ",")
+	runnerConfig.MaxHandlersPerType, err = parseMaxChecksPerType(maxChecksByType)
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+
 	log.Info("Starting %s...", moduleName)
 	// TODO(greg): This intialization is fucking bullshit. Kill me.
-	runner, err := checker.NewNSQRunner(checker.NewRunner(&schema.HttpCheck{}), runnerConfig)
+	for _, t := range runnerConfig.CheckTypes {
+		if t == checker.CloudWatchCheckType {
+			if err := checker.ConnectCloudwatchBezosClient(); err != nil {
+				log.Fatal(err)
+			}
+		}
+	}
+
+	if err := checker.SetWorkerPoolConfigs(poolSizes); err != nil {
+		log.Fatal(err.Error())
+	}
+
+	runner, err := checker.NewNSQRunner(checker.NewRunner(nil), runnerConfig)
 	if err != nil {
 		log.Fatal(err.Error())
 	}
@@ -58,8 +121,10 @@ func main() {
 			switch s {
 			case syscall.SIGTERM, syscall.SIGINT, syscall.SIGQUIT:
 				log.Info("Received signal ", s, ". Stopping.")
-				runner.Stop()
+				runner.GracefulStop(drainTimeout)
 				os.Exit(0)
+			case syscall.SIGHUP:
+				runner.ReloadWorkerPools()
 			}
 		case beatErr := <-beatChan:
 			log.WithError(beatErr).Error("Heartbeat error.")
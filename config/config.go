@@ -12,6 +12,12 @@ var (
 	once   sync.Once
 )
 
+// AWS backends, see Config.AWSBackend.
+const (
+	AWSBackendBezos  = "bezos"
+	AWSBackendDirect = "direct"
+)
+
 // Global config provides shared aws session, metadata, and environmental variables declared in etc/opsee/bastion-env.sh.
 type Config struct {
 	CustomerId          string
@@ -26,7 +32,10 @@ type Config struct {
 	LogLevel            string
 	BezosHost           string
 	ExecutionGroupId    string
-	AWS                 *AWSConfig
+	// AWSBackend is the backend AWS requests are made through first, either
+	// AWSBackendBezos or AWSBackendDirect.
+	AWSBackend string
+	AWS        *AWSConfig
 }
 
 func (this *Config) getAWSConfig() {
@@ -49,6 +58,17 @@ func (this *Config) setLogLevel(defaultLevel log.Level) {
 	}
 }
 
+func (this *Config) setAWSBackend(defaultBackend string) {
+	switch this.AWSBackend {
+	case AWSBackendBezos, AWSBackendDirect:
+	case "":
+		this.AWSBackend = defaultBackend
+	default:
+		log.Warnf("Unknown AWS backend %q.  Using default backend %s.", this.AWSBackend, defaultBackend)
+		this.AWSBackend = defaultBackend
+	}
+}
+
 func (this *Config) getEnv() {
 	this.LogLevel = os.Getenv("LOG_LEVEL")
 	this.CustomerId = os.Getenv("CUSTOMER_ID")
@@ -62,6 +82,7 @@ func (this *Config) getEnv() {
 	this.EtcdHost = os.Getenv("ETCD_HOST")
 	this.BezosHost = os.Getenv("BEZOS_HOST")
 	this.ExecutionGroupId = os.Getenv("EXECUTION_GROUP_ID")
+	this.AWSBackend = os.Getenv("AWS_BACKEND")
 }
 
 func GetConfig() *Config {
@@ -69,6 +90,7 @@ func GetConfig() *Config {
 		config = &Config{}
 		config.getEnv()
 		config.setLogLevel(log.ErrorLevel)
+		config.setAWSBackend(AWSBackendBezos)
 		config.getAWSConfig()
 	})
 
@@ -0,0 +1,188 @@
+// Package errs sorts the errors that checks fail with into a small set of
+// categories, so that failures can be grouped by cause wherever they're
+// reported.
+package errs
+
+import (
+	"crypto/x509"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"golang.org/x/net/context"
+)
+
+// Error categories.
+const (
+	// Resolution is a failure to resolve a check's target to the hosts it
+	// runs against.
+	Resolution = "resolution"
+	// DNS is a failure to look up a host name.
+	DNS = "dns"
+	// ConnectionRefused is a TCP connect that was refused.
+	ConnectionRefused = "connection_refused"
+	// Connection is any other failure to connect, or a connection that was
+	// lost.
+	Connection = "connection"
+	// Timeout is a request that didn't finish in time.
+	Timeout = "timeout"
+	// TLS is a failed TLS handshake, including a certificate that couldn't be
+	// verified.
+	TLS = "tls"
+	// HTTPProtocol is a response that couldn't be understood.
+	HTTPProtocol = "http_protocol"
+	// Assertion is a response that didn't meet the check's expectations.
+	Assertion = "assertion"
+	// AWSThrottling is an AWS API request, made directly or through bezos,
+	// that was throttled.
+	AWSThrottling = "aws_throttling"
+	// RateLimited is a request the bastion held back because it was over its
+	// target's rate limits.
+	RateLimited = "rate_limited"
+	// Internal is a failure of the bastion itself, or one that couldn't be
+	// categorized.
+	Internal = "internal"
+)
+
+// An Error is an error with a category.
+type Error struct {
+	Category string
+	Err      error
+}
+
+// New returns an Error of the category with the given message.
+func New(category, message string) *Error {
+	return &Error{Category: category, Err: fmt.Errorf("%s", message)}
+}
+
+// Errorf returns an Error of the category, formatting its message.
+func Errorf(category, format string, args ...interface{}) *Error {
+	return &Error{Category: category, Err: fmt.Errorf(format, args...)}
+}
+
+// Wrap returns err as an Error. Its category is the one Classify finds, or
+// fallback if it finds none more specific than Internal. Wrap returns nil if
+// err is nil.
+func Wrap(err error, fallback string) *Error {
+	if err == nil {
+		return nil
+	}
+	if e, ok := err.(*Error); ok {
+		return e
+	}
+
+	category := Classify(err)
+	if category == Internal {
+		category = fallback
+	}
+	return &Error{Category: category, Err: err}
+}
+
+// Wrapf returns an Error with err's category, whose message is the formatted
+// string followed by err's.
+func Wrapf(err error, format string, args ...interface{}) *Error {
+	return &Error{
+		Category: Classify(err),
+		Err:      fmt.Errorf("%s: %s", fmt.Sprintf(format, args...), err),
+	}
+}
+
+func (e *Error) Error() string {
+	return e.Err.Error()
+}
+
+// Categorized is implemented by errors that know their own category.
+type Categorized interface {
+	error
+	ErrorCategory() string
+}
+
+// ErrorCategory implements Categorized.
+func (e *Error) ErrorCategory() string {
+	return e.Category
+}
+
+var awsThrottleCodes = map[string]bool{
+	"Throttling":                             true,
+	"ThrottlingException":                    true,
+	"RequestLimitExceeded":                   true,
+	"RequestThrottled":                       true,
+	"TooManyRequestsException":               true,
+	"ProvisionedThroughputExceededException": true,
+}
+
+// Classify returns the category of err, or Internal if it can't tell. Errors
+// that know their category are taken at their word. Otherwise it goes by the
+// type of error, and failing that its message, since some errors (e.g. those
+// from bezos) only arrive as strings.
+func Classify(err error) string {
+	if err == nil {
+		return ""
+	}
+	if err == context.DeadlineExceeded {
+		return Timeout
+	}
+
+	switch e := err.(type) {
+	case Categorized:
+		return e.ErrorCategory()
+	case *url.Error:
+		return Classify(e.Err)
+	case *net.OpError:
+		if e.Timeout() {
+			return Timeout
+		}
+		if _, ok := e.Err.(*net.DNSError); ok {
+			return DNS
+		}
+		// e.g. refused connects, and TLS alerts read from the connection
+		if category := classifyMessage(e.Err.Error()); category != Internal {
+			return category
+		}
+		if e.Op == "dial" || e.Op == "read" || e.Op == "write" {
+			return Connection
+		}
+	case *net.DNSError:
+		return DNS
+	case x509.UnknownAuthorityError, x509.HostnameError, x509.CertificateInvalidError:
+		return TLS
+	case awserr.RequestFailure:
+		if e.StatusCode() == 429 || awsThrottleCodes[e.Code()] {
+			return AWSThrottling
+		}
+	case awserr.Error:
+		if awsThrottleCodes[e.Code()] {
+			return AWSThrottling
+		}
+	case net.Error:
+		if e.Timeout() {
+			return Timeout
+		}
+	}
+
+	return classifyMessage(err.Error())
+}
+
+func classifyMessage(msg string) string {
+	msg = strings.ToLower(msg)
+	switch {
+	case strings.Contains(msg, "throttl") || strings.Contains(msg, "rate exceeded") || strings.Contains(msg, "requestlimitexceeded"):
+		return AWSThrottling
+	case strings.Contains(msg, "timeout") || strings.Contains(msg, "timed out") || strings.Contains(msg, "deadline exceeded"):
+		return Timeout
+	case strings.Contains(msg, "no such host"):
+		return DNS
+	case strings.Contains(msg, "tls:") || strings.Contains(msg, "x509:"):
+		return TLS
+	case strings.Contains(msg, "connection refused"):
+		return ConnectionRefused
+	case strings.Contains(msg, "connection reset") || strings.Contains(msg, "broken pipe") || strings.Contains(msg, "eof"):
+		return Connection
+	case strings.Contains(msg, "malformed http") || strings.Contains(msg, "gave http response") || strings.Contains(msg, "bad handshake"):
+		return HTTPProtocol
+	}
+
+	return Internal
+}
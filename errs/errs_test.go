@@ -0,0 +1,59 @@
+package errs
+
+import (
+	"crypto/x509"
+	"errors"
+	"net"
+	"net/url"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/context"
+)
+
+func TestClassify(t *testing.T) {
+	refused := &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connect: connection refused")}
+	reset := &net.OpError{Op: "read", Net: "tcp", Err: errors.New("read: connection reset by peer")}
+
+	cases := map[error]string{
+		nil:                      "",
+		context.DeadlineExceeded: Timeout,
+		&net.DNSError{Err: "no such host", Name: "nope"}: DNS,
+		refused: ConnectionRefused,
+		reset:   Connection,
+		&url.Error{Op: "Get", URL: "http://127.0.0.1/", Err: refused}: ConnectionRefused,
+		x509.UnknownAuthorityError{}:                                  TLS,
+		errors.New("remote error: tls: handshake failure"):            TLS,
+		errors.New("malformed HTTP response \"foo\""):                 HTTPProtocol,
+		awserr.New("Throttling", "Rate exceeded", nil):                AWSThrottling,
+		awserr.New("AccessDenied", "Denied", nil):                     Internal,
+		errors.New("rpc error: code = 2 desc = ThrottlingException"):  AWSThrottling,
+		errors.New("something else"):                                  Internal,
+		New(Assertion, "did not match"):                               Assertion,
+	}
+
+	for err, category := range cases {
+		assert.Equal(t, category, Classify(err), "%v", err)
+	}
+}
+
+func TestWrap(t *testing.T) {
+	assert.Nil(t, Wrap(nil, Resolution))
+
+	err := Wrap(errors.New("Invalid target"), Resolution)
+	assert.Equal(t, Resolution, err.Category)
+	assert.Equal(t, "Invalid target", err.Error())
+
+	err = Wrap(errors.New("Throttling: Rate exceeded"), Resolution)
+	assert.Equal(t, AWSThrottling, err.Category)
+
+	original := New(Timeout, "too slow")
+	assert.Equal(t, original, Wrap(original, Resolution))
+}
+
+func TestWrapf(t *testing.T) {
+	err := Wrapf(New(Assertion, "did not match"), "step %d", 2)
+	assert.Equal(t, Assertion, err.Category)
+	assert.Equal(t, "step 2: did not match", err.Error())
+}